@@ -24,48 +24,67 @@ package main
 
 import (
 	"context"
+	"os"
 
-	"com.aws-samples/apigateway.websockets.golang/lib/apigw"
+	"com.aws-samples/apigateway.websockets.golang/lib/handler/connect"
 	"com.aws-samples/apigateway.websockets.golang/lib/logger"
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/policy"
 	"com.aws-samples/apigateway.websockets.golang/lib/redis"
-	"github.com/aws/aws-lambda-go/events"
+	"com.aws-samples/apigateway.websockets.golang/lib/tenant"
+	"com.aws-samples/apigateway.websockets.golang/lib/tracing"
+	"com.aws-samples/apigateway.websockets.golang/lib/user"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
 
 	"github.com/aws/aws-lambda-go/lambda"
-	radix "github.com/mediocregopher/radix/v3"
 	"go.uber.org/zap"
 )
 
+// main creates the handler's dependencies once per AWS Lambda execution context and starts the handler. Creating the
+// dependencies outside of the handler allows them to be reused across subsequent invocations.
 func main() {
-	lambda.Start(handler)
-}
+	// Use the SDK default configuration, loading additional config and credentials values from the environment
+	// variables, shared credentials, and shared configuration files.
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		logger.Instance.Panic("unable to load SDK config", zap.Error(err))
+	}
 
-// handler receives a synchronous invocation from API Gateway when a new WebSocket connection is created for the
-// application's API. The connection details are cached in the application's Redis cache which makes the connection
-// available to the other application components.
-func handler(_ context.Context, req *events.APIGatewayWebsocketProxyRequest) (apigw.Response, error) {
-	defer func() {
-		_ = logger.Instance.Sync()
-	}()
+	if _, err := tracing.Setup(context.Background(), "connect"); err != nil {
+		logger.Instance.Panic("unable to configure tracing", zap.Error(err))
+	}
 
-	logger.Instance.Info("websocket connect",
-		zap.String("requestId", req.RequestContext.RequestID),
-		zap.String("connectionId", req.RequestContext.ConnectionID))
+	tenantOptions, err := tenant.OptionsFromEnv()
+	if err != nil {
+		logger.Instance.Panic("unable to read tenant configuration", zap.Error(err))
+	}
 
-	var result string
-	err := redis.Client.Do(radix.Cmd(&result, "SADD", "connections", req.RequestContext.ConnectionID))
+	userOptions, err := user.OptionsFromEnv()
 	if err != nil {
-		logger.Instance.Error("failed to cache connection details",
-			zap.String("requestId", req.RequestContext.RequestID),
-			zap.String("connectionId", req.RequestContext.ConnectionID),
-			zap.Error(err))
+		logger.Instance.Panic("unable to read user configuration", zap.Error(err))
+	}
 
-		return apigw.InternalServerErrorResponse(), err
+	policyOptions, err := policy.OptionsFromEnv()
+	if err != nil {
+		logger.Instance.Panic("unable to read channel policy configuration", zap.Error(err))
+	}
+
+	opts, err := redis.OptionsFromEnv()
+	if err != nil {
+		logger.Instance.Panic("unable to read redis configuration", zap.Error(err))
 	}
 
-	logger.Instance.Info("websocket connection cached",
-		zap.String("result", result),
-		zap.String("requestId", req.RequestContext.RequestID),
-		zap.String("connectionId", req.RequestContext.ConnectionID))
+	client, err := redis.NewClient(opts)
+	if err != nil {
+		logger.Instance.Panic("unable to create redis client", zap.Error(err))
+	}
 
-	return apigw.OkResponse(), nil
+	lambda.Start(connect.NewHandler(connect.Dependencies{
+		Tenants: tenant.NewResolver(client, tenantOptions),
+		Policy:  policy.NewEngine(client, policyOptions),
+		Users:   user.NewLimiter(client, userOptions),
+		Config:  cfg,
+		Redis:   client,
+		Metrics: metrics.NewEmitter(metrics.NamespaceFromEnv(), metrics.NewWriterSink(os.Stdout)),
+	}).Handle)
 }
@@ -24,48 +24,51 @@ package main
 
 import (
 	"context"
+	"os"
 
-	"com.aws-samples/apigateway.websockets.golang/lib/apigw"
+	"com.aws-samples/apigateway.websockets.golang/lib/handler/disconnect"
 	"com.aws-samples/apigateway.websockets.golang/lib/logger"
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
 	"com.aws-samples/apigateway.websockets.golang/lib/redis"
-	"github.com/aws/aws-lambda-go/events"
+	"com.aws-samples/apigateway.websockets.golang/lib/tenant"
+	"com.aws-samples/apigateway.websockets.golang/lib/tracing"
+	"com.aws-samples/apigateway.websockets.golang/lib/user"
 
 	"github.com/aws/aws-lambda-go/lambda"
-	radix "github.com/mediocregopher/radix/v3"
 	"go.uber.org/zap"
 )
 
+// main creates the handler's dependencies once per AWS Lambda execution context and starts the handler. Creating the
+// dependencies outside of the handler allows them to be reused across subsequent invocations.
 func main() {
-	lambda.Start(handler)
-}
-
-// handler receives a synchronous invocation from API Gateway when a new connection has been disconnected from the
-// application's API. The connection details are removed in the application's Redis cache which cleans up the connection
-// details. This handler is not guaranteed to be called when the WebSocket connection is closed.
-func handler(_ context.Context, req *events.APIGatewayWebsocketProxyRequest) (apigw.Response, error) {
-	defer func() {
-		_ = logger.Instance.Sync()
-	}()
+	if _, err := tracing.Setup(context.Background(), "disconnect"); err != nil {
+		logger.Instance.Panic("unable to configure tracing", zap.Error(err))
+	}
 
-	logger.Instance.Info("websocket disconnect",
-		zap.String("requestId", req.RequestContext.RequestID),
-		zap.String("connectionId", req.RequestContext.ConnectionID))
+	tenantOptions, err := tenant.OptionsFromEnv()
+	if err != nil {
+		logger.Instance.Panic("unable to read tenant configuration", zap.Error(err))
+	}
 
-	var result string
-	err := redis.Client.Do(radix.Cmd(&result, "SREM", "connections", req.RequestContext.ConnectionID))
+	userOptions, err := user.OptionsFromEnv()
 	if err != nil {
-		logger.Instance.Error("failed to delete connection details from cache",
-			zap.String("requestId", req.RequestContext.RequestID),
-			zap.String("connectionId", req.RequestContext.ConnectionID),
-			zap.Error(err))
+		logger.Instance.Panic("unable to read user configuration", zap.Error(err))
+	}
 
-		return apigw.InternalServerErrorResponse(), err
+	opts, err := redis.OptionsFromEnv()
+	if err != nil {
+		logger.Instance.Panic("unable to read redis configuration", zap.Error(err))
 	}
 
-	logger.Instance.Info("websocket connection deleted from cache",
-		zap.String("result", result),
-		zap.String("requestId", req.RequestContext.RequestID),
-		zap.String("connectionId", req.RequestContext.ConnectionID))
+	client, err := redis.NewClient(opts)
+	if err != nil {
+		logger.Instance.Panic("unable to create redis client", zap.Error(err))
+	}
 
-	return apigw.OkResponse(), nil
+	lambda.Start(disconnect.NewHandler(disconnect.Dependencies{
+		Tenants: tenant.NewResolver(client, tenantOptions),
+		Users:   user.NewLimiter(client, userOptions),
+		Redis:   client,
+		Metrics: metrics.NewEmitter(metrics.NamespaceFromEnv(), metrics.NewWriterSink(os.Stdout)),
+	}).Handle)
 }
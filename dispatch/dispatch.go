@@ -0,0 +1,66 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw"
+	"com.aws-samples/apigateway.websockets.golang/lib/handler/publish"
+	"com.aws-samples/apigateway.websockets.golang/lib/logger"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"com.aws-samples/apigateway.websockets.golang/lib/tracing"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"go.uber.org/zap"
+)
+
+// main creates the handler's dependencies once per AWS Lambda execution context and starts the handler. Creating the
+// dependencies outside of the handler allows them to be reused across subsequent invocations.
+func main() {
+	cfg, err := apigw.LoadConfig()
+	if err != nil {
+		logger.Instance.Panic("unable to load SDK config", zap.Error(err))
+	}
+
+	if _, err := tracing.Setup(context.Background(), "dispatch"); err != nil {
+		logger.Instance.Panic("unable to configure tracing", zap.Error(err))
+	}
+
+	opts, err := redis.OptionsFromEnv()
+	if err != nil {
+		logger.Instance.Panic("unable to read redis configuration", zap.Error(err))
+	}
+
+	client, err := redis.NewClient(opts)
+	if err != nil {
+		logger.Instance.Panic("unable to create redis client", zap.Error(err))
+	}
+
+	deps, err := publish.DependenciesFromEnv(client, cfg)
+	if err != nil {
+		logger.Instance.Panic("unable to read dispatch configuration", zap.Error(err))
+	}
+
+	lambda.Start(publish.NewHandler(deps).Dispatch)
+}
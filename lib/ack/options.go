@@ -0,0 +1,98 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ack
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Environment variables read by OptionsFromEnv.
+const (
+	EnvTimeout     = "ACK_TIMEOUT"
+	EnvMaxAttempts = "ACK_MAX_ATTEMPTS"
+	EnvRetention   = "ACK_RETENTION"
+	EnvDeadLetters = "ACK_MAX_DEAD_LETTERS"
+)
+
+// Options configures the Store.
+type Options struct {
+	// Timeout is how long a recipient has to acknowledge a delivery before it is redelivered. The timeout doubles with
+	// each attempt.
+	Timeout time.Duration
+
+	// MaxAttempts is the number of deliveries after which an unacknowledged delivery is moved to the dead-letter list.
+	MaxAttempts int
+
+	// Retention is how long the payload of a message is kept for redelivery.
+	Retention time.Duration
+
+	// MaxDeadLetters caps the length of the dead-letter list. The oldest entries are dropped first.
+	MaxDeadLetters int
+}
+
+// DefaultOptions returns the Options used when no environment variables are set.
+func DefaultOptions() Options {
+	return Options{
+		Timeout:        30 * time.Second,
+		MaxAttempts:    5,
+		Retention:      time.Hour,
+		MaxDeadLetters: 10000,
+	}
+}
+
+// OptionsFromEnv returns DefaultOptions overridden by any of the ACK_* environment variables which are set.
+func OptionsFromEnv() (Options, error) {
+	opts := DefaultOptions()
+	for name, d := range map[string]*time.Duration{
+		EnvTimeout:   &opts.Timeout,
+		EnvRetention: &opts.Retention,
+	} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil || parsed < time.Second {
+				return opts, fmt.Errorf("invalid %s %q: must be a duration of at least 1s", name, v)
+			}
+
+			*d = parsed
+		}
+	}
+
+	for name, n := range map[string]*int{
+		EnvMaxAttempts: &opts.MaxAttempts,
+		EnvDeadLetters: &opts.MaxDeadLetters,
+	} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed < 1 {
+				return opts, fmt.Errorf("invalid %s %q: must be a positive integer", name, v)
+			}
+
+			*n = parsed
+		}
+	}
+
+	return opts, nil
+}
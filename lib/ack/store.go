@@ -0,0 +1,242 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package ack provides at-least-once delivery of messages. A message published in acknowledgement mode is retained,
+// and each delivery to a connection is tracked until the recipient acknowledges the message ID. Unacknowledged
+// deliveries are redelivered with an exponential backoff, and moved to a dead-letter list once the maximum number of
+// attempts is reached, the message expired, or the connection is gone.
+package ack
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// Reasons recorded with dead-lettered deliveries.
+const (
+	ReasonMaxAttempts = "max-attempts"
+	ReasonExpired     = "expired"
+	ReasonGone        = "gone"
+	ReasonRejected    = "rejected"
+)
+
+// Delivery identifies a message sent to a connection of a tenant which awaits acknowledgement.
+type Delivery struct {
+	Tenant       string `json:"tenant"`
+	ConnectionID string `json:"connectionId"`
+	MessageID    string `json:"messageId"`
+}
+
+// keyspace returns the Keyspace of the delivery's tenant.
+func (d Delivery) keyspace() redis.Keyspace {
+	return redis.Tenant(d.Tenant)
+}
+
+// DeadLetter is an entry of the dead-letter list.
+type DeadLetter struct {
+	Delivery
+	Attempts int    `json:"attempts"`
+	Reason   string `json:"reason"`
+	Time     int64  `json:"time"`
+}
+
+// Store tracks unacknowledged deliveries in Redis.
+type Store struct {
+	redis redis.Client
+	opts  Options
+}
+
+// NewStore creates a new Store. Zero values in the provided Options are replaced by the values of DefaultOptions.
+func NewStore(client redis.Client, opts Options) *Store {
+	defaults := DefaultOptions()
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaults.Timeout
+	}
+
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaults.MaxAttempts
+	}
+
+	if opts.Retention <= 0 {
+		opts.Retention = defaults.Retention
+	}
+
+	if opts.MaxDeadLetters <= 0 {
+		opts.MaxDeadLetters = defaults.MaxDeadLetters
+	}
+
+	return &Store{redis: client, opts: opts}
+}
+
+// Retain stores the payload of the message of the tenant with the provided Keyspace so it can be redelivered. The
+// payload expires after the retention period, or at the provided expiry of the message if that is earlier, after
+// which the pending deliveries of the message are moved to the dead-letter list instead of being redelivered.
+func (s *Store) Retain(ctx context.Context, ks redis.Keyspace, messageID string, payload []byte,
+	expires time.Time) error {
+	retention := s.opts.Retention
+	if !expires.IsZero() && time.Until(expires) < retention {
+		retention = time.Until(expires)
+	}
+
+	if retention < time.Millisecond {
+		retention = time.Millisecond
+	}
+
+	return redis.Do(ctx, s.redis, "SET", radix.FlatCmd(nil, "SET", ks.MessageKey(messageID, "payload"), payload,
+		"PX", milliseconds(retention)))
+}
+
+// Track records the first delivery of the message to the connection. The delivery is due for redelivery once the
+// acknowledgement timeout elapses.
+func (s *Store) Track(ctx context.Context, d Delivery) error {
+	pending := d.keyspace().ConnectionKey(d.ConnectionID, "pending")
+	err := redis.Do(ctx, s.redis, "HSET", radix.Cmd(nil, "HSET", pending, d.MessageID, "1"))
+	if err != nil {
+		return err
+	}
+
+	err = redis.Do(ctx, s.redis, "PEXPIRE", radix.Cmd(nil, "PEXPIRE", pending, milliseconds(s.opts.Retention)))
+	if err != nil {
+		return err
+	}
+
+	return s.schedule(ctx, d, 1)
+}
+
+// Ack removes the delivery. It reports whether the delivery was awaiting acknowledgement.
+func (s *Store) Ack(ctx context.Context, d Delivery) (bool, error) {
+	var removed int
+	err := redis.Do(ctx, s.redis, "HDEL",
+		radix.Cmd(&removed, "HDEL", d.keyspace().ConnectionKey(d.ConnectionID, "pending"), d.MessageID))
+	if err != nil {
+		return false, err
+	}
+
+	return removed > 0, redis.Do(ctx, s.redis, "ZREM", radix.Cmd(nil, "ZREM", redis.RedeliveriesKey, member(d)))
+}
+
+// Due returns up to limit deliveries which are due for redelivery at the provided time. The deliveries are read from
+// the primary, as a replica which lags behind the redeliveries drained so far would return them again.
+func (s *Store) Due(ctx context.Context, now time.Time, limit int) ([]Delivery, error) {
+	var members []string
+	err := redis.Do(ctx, s.redis, "ZRANGEBYSCORE", radix.Cmd(&members, "ZRANGEBYSCORE", redis.RedeliveriesKey,
+		"-inf", strconv.FormatInt(now.UnixMilli(), 10), "LIMIT", "0", strconv.Itoa(limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]Delivery, 0, len(members))
+	for _, m := range members {
+		parts := strings.SplitN(m, " ", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		deliveries = append(deliveries, Delivery{Tenant: parts[0], ConnectionID: parts[1], MessageID: parts[2]})
+	}
+
+	return deliveries, nil
+}
+
+// Next prepares the next attempt of the delivery and returns the attempt number and the payload to send. It reports
+// false when the delivery should not be attempted again, either because it was acknowledged in the meantime or because
+// it was moved to the dead-letter list.
+func (s *Store) Next(ctx context.Context, d Delivery) (int, []byte, bool, error) {
+	pending := d.keyspace().ConnectionKey(d.ConnectionID, "pending")
+
+	var n int
+	attempts := radix.MaybeNil{Rcv: &n}
+	if err := redis.Do(ctx, s.redis, "HGET", radix.Cmd(&attempts, "HGET", pending, d.MessageID)); err != nil {
+		return 0, nil, false, err
+	}
+
+	if attempts.Nil {
+		return 0, nil, false, redis.Do(ctx, s.redis, "ZREM", radix.Cmd(nil, "ZREM", redis.RedeliveriesKey, member(d)))
+	}
+
+	if n >= s.opts.MaxAttempts {
+		return n, nil, false, s.DeadLetter(ctx, d, n, ReasonMaxAttempts)
+	}
+
+	var payload []byte
+	stored := radix.MaybeNil{Rcv: &payload}
+	if err := redis.Do(ctx, s.redis, "GET", radix.Cmd(&stored, "GET", d.keyspace().MessageKey(d.MessageID, "payload"))); err != nil {
+		return n, nil, false, err
+	}
+
+	if stored.Nil {
+		return n, nil, false, s.DeadLetter(ctx, d, n, ReasonExpired)
+	}
+
+	n++
+	err := redis.Do(ctx, s.redis, "HSET", radix.Cmd(nil, "HSET", pending, d.MessageID, strconv.Itoa(n)))
+	if err != nil {
+		return n, nil, false, err
+	}
+
+	return n, payload, true, s.schedule(ctx, d, n)
+}
+
+// DeadLetter removes the delivery and appends it to the dead-letter list.
+func (s *Store) DeadLetter(ctx context.Context, d Delivery, attempts int, reason string) error {
+	entry, err := json.Marshal(DeadLetter{Delivery: d, Attempts: attempts, Reason: reason, Time: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.Ack(ctx, d); err != nil {
+		return err
+	}
+
+	err = redis.Do(ctx, s.redis, "LPUSH", radix.Cmd(nil, "LPUSH", d.keyspace().DeadLettersKey(), string(entry)))
+	if err != nil {
+		return err
+	}
+
+	return redis.Do(ctx, s.redis, "LTRIM", radix.Cmd(nil, "LTRIM", d.keyspace().DeadLettersKey(), "0",
+		strconv.Itoa(s.opts.MaxDeadLetters-1)))
+}
+
+// schedule sets the time the delivery is due for redelivery after the provided attempt. The acknowledgement timeout
+// doubles with each attempt.
+func (s *Store) schedule(ctx context.Context, d Delivery, attempt int) error {
+	due := time.Now().Add(s.opts.Timeout << uint(attempt-1))
+	return redis.Do(ctx, s.redis, "ZADD", radix.Cmd(nil, "ZADD", redis.RedeliveriesKey,
+		strconv.FormatInt(due.UnixMilli(), 10), member(d)))
+}
+
+// member encodes the delivery as a member of the redeliveries sorted set. Tenant and connection IDs never contain
+// spaces.
+func member(d Delivery) string {
+	return d.Tenant + " " + d.ConnectionID + " " + d.MessageID
+}
+
+// milliseconds formats the duration as an integer number of milliseconds.
+func milliseconds(d time.Duration) string {
+	return strconv.FormatInt(d.Milliseconds(), 10)
+}
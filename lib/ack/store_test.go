@@ -0,0 +1,202 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ack
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis/redistest"
+)
+
+func TestTrackAndAck(t *testing.T) {
+	client := redistest.NewStore()
+	s := NewStore(client, Options{Timeout: time.Minute})
+	ctx := context.Background()
+	d := Delivery{Tenant: "acme", ConnectionID: "conn1", MessageID: "m1"}
+
+	start := time.Now()
+	if err := s.Track(ctx, d); err != nil {
+		t.Fatalf("Track returned error: %v", err)
+	}
+
+	if due, err := s.Due(ctx, start, 10); err != nil || len(due) != 0 {
+		t.Errorf("Due returned %v, %v before the acknowledgement timeout", due, err)
+	}
+
+	due, err := s.Due(ctx, start.Add(2*time.Minute), 10)
+	if err != nil || !reflect.DeepEqual(due, []Delivery{d}) {
+		t.Errorf("Due returned %v, %v, want %v", due, err, []Delivery{d})
+	}
+
+	if pending, err := s.Ack(ctx, d); !pending || err != nil {
+		t.Errorf("Ack returned %v, %v, want true", pending, err)
+	}
+
+	if pending, err := s.Ack(ctx, d); pending || err != nil {
+		t.Errorf("second Ack returned %v, %v, want false", pending, err)
+	}
+
+	if due, err := s.Due(ctx, start.Add(2*time.Minute), 10); err != nil || len(due) != 0 {
+		t.Errorf("Due returned %v, %v after the acknowledgement", due, err)
+	}
+}
+
+func TestNext(t *testing.T) {
+	client := redistest.NewStore()
+	s := NewStore(client, Options{Timeout: time.Minute, MaxAttempts: 3})
+	ctx := context.Background()
+	d := Delivery{Tenant: "acme", ConnectionID: "conn1", MessageID: "m1"}
+	if err := s.Retain(ctx, redis.Tenant("acme"), "m1", []byte("payload"), time.Time{}); err != nil {
+		t.Fatalf("Retain returned error: %v", err)
+	}
+
+	if err := s.Track(ctx, d); err != nil {
+		t.Fatalf("Track returned error: %v", err)
+	}
+
+	// Each redelivery doubles the acknowledgement timeout.
+	for attempt := 2; attempt <= 3; attempt++ {
+		before := time.Now()
+		n, payload, ok, err := s.Next(ctx, d)
+		if err != nil || !ok || n != attempt || string(payload) != "payload" {
+			t.Fatalf("Next returned %d, %q, %v, %v, want attempt %d", n, payload, ok, err, attempt)
+		}
+
+		due := time.UnixMilli(int64(client.ZSets[redis.RedeliveriesKey][member(d)]))
+		if timeout := time.Minute << uint(attempt-1); due.Before(before.Add(timeout).Truncate(time.Millisecond)) {
+			t.Errorf("attempt %d is due at %v, want %v after %v", attempt, due, timeout, before)
+		}
+	}
+
+	// The delivery reached the maximum number of attempts, so it is moved to the dead-letter list.
+	if _, _, ok, err := s.Next(ctx, d); ok || err != nil {
+		t.Fatalf("Next returned %v, %v, want false", ok, err)
+	}
+
+	letters := deadLetters(t, client, "acme")
+	if len(letters) != 1 || letters[0].Delivery != d || letters[0].Reason != ReasonMaxAttempts ||
+		letters[0].Attempts != 3 {
+		t.Errorf("dead letters are %+v, want the delivery after 3 attempts", letters)
+	}
+
+	if _, ok := client.ZSets[redis.RedeliveriesKey][member(d)]; ok {
+		t.Error("dead-lettered delivery is still scheduled")
+	}
+}
+
+func TestNextExpired(t *testing.T) {
+	client := redistest.NewStore()
+	s := NewStore(client, DefaultOptions())
+	ctx := context.Background()
+	d := Delivery{Tenant: "acme", ConnectionID: "conn1", MessageID: "m1"}
+	if err := s.Track(ctx, d); err != nil {
+		t.Fatalf("Track returned error: %v", err)
+	}
+
+	// The payload of the message was not retained, as if it expired.
+	if _, _, ok, err := s.Next(ctx, d); ok || err != nil {
+		t.Fatalf("Next returned %v, %v, want false", ok, err)
+	}
+
+	if letters := deadLetters(t, client, "acme"); len(letters) != 1 || letters[0].Reason != ReasonExpired {
+		t.Errorf("dead letters are %+v, want the expired delivery", letters)
+	}
+}
+
+func TestNextAcknowledged(t *testing.T) {
+	client := redistest.NewStore()
+	s := NewStore(client, DefaultOptions())
+	ctx := context.Background()
+	d := Delivery{Tenant: "acme", ConnectionID: "conn1", MessageID: "m1"}
+	if err := s.Track(ctx, d); err != nil {
+		t.Fatalf("Track returned error: %v", err)
+	}
+
+	// The delivery was acknowledged after it was read as due, but before it was redelivered.
+	delete(client.Hashes, redis.Tenant("acme").ConnectionKey("conn1", "pending"))
+	if _, _, ok, err := s.Next(ctx, d); ok || err != nil {
+		t.Fatalf("Next returned %v, %v, want false", ok, err)
+	}
+
+	if _, ok := client.ZSets[redis.RedeliveriesKey][member(d)]; ok {
+		t.Error("acknowledged delivery is still scheduled")
+	}
+
+	if letters := deadLetters(t, client, "acme"); len(letters) != 0 {
+		t.Errorf("acknowledged delivery was dead-lettered: %+v", letters)
+	}
+}
+
+func TestDeadLetterTrim(t *testing.T) {
+	client := redistest.NewStore()
+	s := NewStore(client, Options{MaxDeadLetters: 2})
+	ctx := context.Background()
+	for _, id := range []string{"m1", "m2", "m3"} {
+		d := Delivery{Tenant: "acme", ConnectionID: "conn1", MessageID: id}
+		if err := s.DeadLetter(ctx, d, 1, ReasonGone); err != nil {
+			t.Fatalf("DeadLetter returned error: %v", err)
+		}
+	}
+
+	letters := deadLetters(t, client, "acme")
+	if len(letters) != 2 || letters[0].MessageID != "m3" || letters[1].MessageID != "m2" {
+		t.Errorf("dead letters are %+v, want the 2 most recent", letters)
+	}
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Setenv(EnvTimeout, "10s")
+	t.Setenv(EnvMaxAttempts, "7")
+	opts, err := OptionsFromEnv()
+	if err != nil || opts.Timeout != 10*time.Second || opts.MaxAttempts != 7 ||
+		opts.Retention != DefaultOptions().Retention {
+		t.Errorf("OptionsFromEnv returned %+v, %v", opts, err)
+	}
+
+	t.Setenv(EnvDeadLetters, "0")
+	if _, err := OptionsFromEnv(); err == nil {
+		t.Errorf("OptionsFromEnv accepted %s 0", EnvDeadLetters)
+	}
+}
+
+// deadLetters decodes the dead-letter list of the tenant, most recent first.
+func deadLetters(t *testing.T, client *redistest.Store, tenant string) []DeadLetter {
+	t.Helper()
+
+	var letters []DeadLetter
+	for _, entry := range client.Lists[redis.Tenant(tenant).DeadLettersKey()] {
+		var l DeadLetter
+		if err := json.Unmarshal([]byte(entry), &l); err != nil {
+			t.Fatalf("invalid dead letter %q: %v", entry, err)
+		}
+
+		letters = append(letters, l)
+	}
+
+	return letters
+}
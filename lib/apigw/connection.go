@@ -0,0 +1,145 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package apigw
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/tracing"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Timing holds the timings of a call to the Amazon API Gateway Management API. Connect and TLSHandshake are only set
+// when the call opened a new connection instead of reusing an idle one.
+type Timing struct {
+	Total        time.Duration
+	FirstByte    time.Duration
+	Connect      time.Duration
+	TLSHandshake time.Duration
+	Reused       bool
+}
+
+// Record records the timings as metrics with the provided recorder.
+func (t Timing) Record(rec *metrics.Recorder) {
+	rec.Duration(metrics.PublishLatency, t.Total)
+	if t.FirstByte > 0 {
+		rec.Duration(metrics.TimeToFirstByte, t.FirstByte)
+	}
+
+	if t.Reused {
+		rec.Increment(metrics.HTTPConnectionsReused, 1)
+		return
+	}
+
+	rec.Increment(metrics.HTTPConnectionsOpened, 1)
+	if t.Connect > 0 {
+		rec.Duration(metrics.ConnectLatency, t.Connect)
+	}
+
+	if t.TLSHandshake > 0 {
+		rec.Duration(metrics.TLSHandshakeLatency, t.TLSHandshake)
+	}
+}
+
+// PostToConnection sends the provided data to the provided Amazon API Gateway connection ID. A common failure scenario
+// which results in an error is if the connection ID is no longer valid. This can occur when a client disconnected from
+// the Amazon API Gateway endpoint but the disconnect AWS Lambda was not invoked as it is not guaranteed to be invoked
+// when clients disconnect. See Classify.
+func PostToConnection(ctx context.Context, client *apigatewaymanagementapi.Client, id string,
+	data []byte) (Timing, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "PostToConnection", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("websocket.connection_id", id)))
+
+	// The hooks of the trace may be called from the go routines dialing the connection.
+	var mu sync.Mutex
+	var timing Timing
+	var connectStart, tlsStart time.Time
+	start := time.Now()
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			timing.Reused = info.Reused
+		},
+		ConnectStart: func(string, string) {
+			mu.Lock()
+			defer mu.Unlock()
+			connectStart = time.Now()
+		},
+		ConnectDone: func(string, string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			timing.Connect = time.Since(connectStart)
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			timing.TLSHandshake = time.Since(tlsStart)
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			timing.FirstByte = time.Since(start)
+		},
+	})
+
+	_, err := client.PostToConnectionRequest(&apigatewaymanagementapi.PostToConnectionInput{
+		Data:         data,
+		ConnectionId: aws.String(id),
+	}).Send(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	timing.Total = time.Since(start)
+	span.SetAttributes(attribute.Bool("net.connection.reused", timing.Reused))
+	tracing.End(span, err)
+	return timing, err
+}
+
+// DeleteConnection closes the provided Amazon API Gateway connection ID. API Gateway invokes the disconnect route of
+// the API once the connection is closed. Closing a connection which no longer exists results in an error which is
+// classified as Remove, see Classify.
+func DeleteConnection(ctx context.Context, client *apigatewaymanagementapi.Client, id string) error {
+	ctx, span := tracing.Tracer().Start(ctx, "DeleteConnection", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("websocket.connection_id", id)))
+
+	_, err := client.DeleteConnectionRequest(&apigatewaymanagementapi.DeleteConnectionInput{
+		ConnectionId: aws.String(id),
+	}).Send(ctx)
+
+	tracing.End(span, err)
+	return err
+}
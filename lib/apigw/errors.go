@@ -0,0 +1,117 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package apigw
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+)
+
+// Decision is the action to take after a call to the Amazon API Gateway Management API failed.
+type Decision int
+
+const (
+	// None is returned for calls which did not fail.
+	None Decision = iota
+
+	// Remove means the connection no longer exists and should be removed from the cache.
+	Remove
+
+	// Retry means the failure is likely transient, such as throttling or a server error, and the call may be retried.
+	Retry
+
+	// Drop means the call will not succeed when retried, for example because the payload is too large, but the
+	// connection is healthy. The message is not delivered to the connection.
+	Drop
+
+	// Alert means the call was rejected in a way which affects every connection, such as missing permissions, and
+	// requires the attention of an operator.
+	Alert
+)
+
+// String returns the name of the decision, as used in logs.
+func (d Decision) String() string {
+	switch d {
+	case None:
+		return "none"
+	case Remove:
+		return "remove"
+	case Retry:
+		return "retry"
+	case Drop:
+		return "drop"
+	case Alert:
+		return "alert"
+	default:
+		return "unknown"
+	}
+}
+
+// Classify returns the Decision for the error returned by a call to the Amazon API Gateway Management API. The HTTP
+// status of the response takes precedence over the error code, as the service replies to calls for connections which
+// no longer exist with a 410 status and an empty body, which the SDK reports as a serialization error. A serialization
+// error without a status is a decoding problem of a healthy connection and is retried.
+func Classify(err error) Decision {
+	if err == nil {
+		return None
+	}
+
+	// The invocation ran out of time or was canceled. The call may only be retried by a later invocation.
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return Retry
+	}
+
+	if rerr, ok := err.(awserr.RequestFailure); ok && rerr.StatusCode() > 0 {
+		switch status := rerr.StatusCode(); {
+		case status == http.StatusGone:
+			return Remove
+		case status == http.StatusForbidden:
+			return Alert
+		case status == http.StatusTooManyRequests, status >= http.StatusInternalServerError:
+			return Retry
+		case status >= http.StatusBadRequest:
+			return Drop
+		}
+	}
+
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case apigatewaymanagementapi.ErrCodeGoneException:
+			return Remove
+		case apigatewaymanagementapi.ErrCodeForbiddenException:
+			return Alert
+		case apigatewaymanagementapi.ErrCodePayloadTooLargeException:
+			return Drop
+		case apigatewaymanagementapi.ErrCodeLimitExceededException, aws.ErrCodeSerialization:
+			return Retry
+		}
+	}
+
+	// Errors without a response, such as network errors, are transient.
+	return Retry
+}
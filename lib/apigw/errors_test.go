@@ -0,0 +1,74 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package apigw
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/awserr"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+)
+
+func TestClassify(t *testing.T) {
+	failure := func(code string, status int) error {
+		return awserr.NewRequestFailure(awserr.New(code, "request failed", nil), status, "request-id")
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want Decision
+	}{
+		{"nil", nil, None},
+		{"gone as serialization error", failure(aws.ErrCodeSerialization, http.StatusGone), Remove},
+		{"gone", failure(apigatewaymanagementapi.ErrCodeGoneException, http.StatusGone), Remove},
+		{"forbidden", failure(apigatewaymanagementapi.ErrCodeForbiddenException, http.StatusForbidden), Alert},
+		{"throttled", failure(apigatewaymanagementapi.ErrCodeLimitExceededException, http.StatusTooManyRequests), Retry},
+		{"internal server error", failure("InternalServerError", http.StatusInternalServerError), Retry},
+		{"service unavailable", failure(aws.ErrCodeSerialization, http.StatusServiceUnavailable), Retry},
+		{"payload too large", failure(apigatewaymanagementapi.ErrCodePayloadTooLargeException,
+			http.StatusRequestEntityTooLarge), Drop},
+		{"bad request", failure("BadRequestException", http.StatusBadRequest), Drop},
+		{"serialization error without status", awserr.New(aws.ErrCodeSerialization, "failed to decode", nil), Retry},
+		{"gone without status", awserr.New(apigatewaymanagementapi.ErrCodeGoneException, "gone", nil), Remove},
+		{"payload too large without status",
+			awserr.New(apigatewaymanagementapi.ErrCodePayloadTooLargeException, "too large", nil), Drop},
+		{"canceled", context.Canceled, Retry},
+		{"deadline exceeded", context.DeadlineExceeded, Retry},
+		{"wrapped deadline exceeded", fmt.Errorf("send: %w", context.DeadlineExceeded), Retry},
+		{"network error", errors.New("connection reset by peer"), Retry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -47,3 +47,13 @@ func BadRequestResponse() Response {
 func OkResponse() Response {
 	return Response{StatusCode: http.StatusOK}
 }
+
+// ForbiddenResponse returns an Amazon API Gateway Proxy Response configured with the correct HTTP status code.
+func ForbiddenResponse() Response {
+	return Response{StatusCode: http.StatusForbidden}
+}
+
+// TooManyRequestsResponse returns an Amazon API Gateway Proxy Response configured with the correct HTTP status code.
+func TooManyRequestsResponse() Response {
+	return Response{StatusCode: http.StatusTooManyRequests}
+}
@@ -0,0 +1,163 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package apigw
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+)
+
+// Environment variables read by TransportOptionsFromEnv.
+const (
+	EnvMaxIdleConnsPerHost = "APIGW_MAX_IDLE_CONNS_PER_HOST"
+	EnvIdleConnTimeout     = "APIGW_IDLE_CONN_TIMEOUT"
+	EnvKeepAlive           = "APIGW_KEEP_ALIVE"
+	EnvHTTP2               = "APIGW_HTTP2"
+	EnvRequestTimeout      = "APIGW_REQUEST_TIMEOUT"
+)
+
+// TransportOptions configures the HTTP client used to call the Amazon API Gateway Management API. All calls go to the
+// same host, so the number of idle connections kept per host must cover the number of concurrent calls, otherwise
+// connections are closed after each call and each new connection pays for a TLS handshake.
+type TransportOptions struct {
+	// MaxIdleConnsPerHost is the number of idle connections kept for reuse.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is how long an idle connection is kept before it is closed.
+	IdleConnTimeout time.Duration
+
+	// KeepAlive is the interval of TCP keep-alive probes of open connections.
+	KeepAlive time.Duration
+
+	// HTTP2 attempts to use HTTP/2, which multiplexes concurrent calls over fewer connections.
+	HTTP2 bool
+
+	// RequestTimeout limits the duration of a single call, including retries. Zero means no limit besides the deadline
+	// of the context.
+	RequestTimeout time.Duration
+}
+
+// DefaultTransportOptions returns the TransportOptions used when no environment variables are set.
+func DefaultTransportOptions() TransportOptions {
+	return TransportOptions{
+		MaxIdleConnsPerHost: 64,
+		IdleConnTimeout:     90 * time.Second,
+		KeepAlive:           30 * time.Second,
+		HTTP2:               true,
+	}
+}
+
+// TransportOptionsFromEnv returns DefaultTransportOptions overridden by any of the APIGW_* environment variables which
+// are set.
+func TransportOptionsFromEnv() (TransportOptions, error) {
+	opts := DefaultTransportOptions()
+	if v := os.Getenv(EnvMaxIdleConnsPerHost); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return opts, fmt.Errorf("invalid %s %q: must be a positive integer", EnvMaxIdleConnsPerHost, v)
+		}
+
+		opts.MaxIdleConnsPerHost = n
+	}
+
+	for name, d := range map[string]*time.Duration{
+		EnvIdleConnTimeout: &opts.IdleConnTimeout,
+		EnvKeepAlive:       &opts.KeepAlive,
+		EnvRequestTimeout:  &opts.RequestTimeout,
+	} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil || parsed < 0 {
+				return opts, fmt.Errorf("invalid %s %q: must be a non-negative duration", name, v)
+			}
+
+			*d = parsed
+		}
+	}
+
+	if v := os.Getenv(EnvHTTP2); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s %q: %w", EnvHTTP2, v, err)
+		}
+
+		opts.HTTP2 = b
+	}
+
+	return opts, nil
+}
+
+// NewHTTPClient creates the HTTP client for the Amazon API Gateway Management API from the provided options. The
+// client should be created once and set as the HTTPClient of the AWS configuration, so that all API clients created
+// from the configuration share its connections.
+func NewHTTPClient(opts TransportOptions) aws.HTTPClient {
+	client := aws.NewBuildableHTTPClient().
+		WithTransportOptions(func(tr *http.Transport) {
+			tr.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+			if tr.MaxIdleConns != 0 && tr.MaxIdleConns < opts.MaxIdleConnsPerHost {
+				tr.MaxIdleConns = opts.MaxIdleConnsPerHost
+			}
+
+			tr.IdleConnTimeout = opts.IdleConnTimeout
+			tr.ForceAttemptHTTP2 = opts.HTTP2
+			if !opts.HTTP2 {
+				// A non-nil, empty map disables the automatic upgrade to HTTP/2.
+				tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+			}
+		}).(*aws.BuildableHTTPClient).
+		WithDialerOptions(func(d *net.Dialer) {
+			d.KeepAlive = opts.KeepAlive
+		}).(*aws.BuildableHTTPClient)
+
+	if opts.RequestTimeout > 0 {
+		return client.WithTimeout(opts.RequestTimeout)
+	}
+
+	return client
+}
+
+// LoadConfig loads the SDK default configuration and sets its HTTP client to one created from TransportOptionsFromEnv.
+// All API clients created from the configuration share the client, so that concurrent calls to the Amazon API Gateway
+// Management API reuse their connections.
+func LoadConfig() (aws.Config, error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return cfg, err
+	}
+
+	opts, err := TransportOptionsFromEnv()
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.HTTPClient = NewHTTPClient(opts)
+	return cfg, nil
+}
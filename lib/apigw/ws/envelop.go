@@ -23,7 +23,18 @@
 // Package ws provides common resources for working with Amazon API Gateway WebSockets
 package ws
 
-import "encoding/json"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+// MaxIDLength is the maximum length of a client supplied message ID.
+const MaxIDLength = 128
+
+// ErrInvalidID is returned by ValidateID for message IDs which can not be used to build keys.
+var ErrInvalidID = errors.New("invalid message id")
 
 // InputEnvelop defines the expected structure for incoming messages sent over the WebSocket connection. The envelop
 // provides additional metadata in addition to the message data.
@@ -31,6 +42,36 @@ type InputEnvelop struct {
 	Echo bool            `json:"echo"`
 	Type int             `json:"type"`
 	Data json.RawMessage `json:"data"`
+
+	// Channel optionally names the channel the message is published to. Messages without a channel are published to
+	// the default channel.
+	Channel string `json:"channel,omitempty"`
+
+	// ID optionally identifies the message. A message which is published again with the same ID, for example when the
+	// client retries, is not published a second time. See ValidateID.
+	ID string `json:"id,omitempty"`
+
+	// Ack requests at-least-once delivery. Each recipient must acknowledge the message by sending an AckEnvelop with the
+	// message's ID, otherwise the message is redelivered.
+	Ack bool `json:"ack,omitempty"`
+
+	// Trace optionally holds the W3C trace context of the message's origin. The trace of the publish is linked to it.
+	Trace map[string]string `json:"trace,omitempty"`
+
+	// Exclude optionally lists connection or user IDs which do not receive the message. Only optionally restricts the
+	// recipients to the listed connection or user IDs. A user ID stands for all connections of the user.
+	Exclude []string `json:"exclude,omitempty"`
+	Only    []string `json:"only,omitempty"`
+
+	// TTL optionally sets the number of milliseconds after which the message expires, and ExpiresAt the time at which it
+	// expires in Unix milliseconds. When both are set, the earlier expiry applies. Expired messages are not delivered.
+	TTL       int64 `json:"ttl,omitempty"`
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+
+	// DeliverAt optionally schedules the message for publication at a later time in Unix milliseconds. A scheduled
+	// message requires an ID, by which it can be canceled with a CancelEnvelop until it is published. The TTL of a
+	// scheduled message counts from its publication.
+	DeliverAt int64 `json:"deliverAt,omitempty"`
 }
 
 // Decode decodes and populates the InputEnvelop from the provided bytes.
@@ -42,12 +83,183 @@ func (e *InputEnvelop) Decode(data []byte) (*InputEnvelop, error) {
 // OutputEnvelop defines the structure for messages sent over the WebSocket connection from the backend service. The
 // envelop provides additional metadata in addition to the message data.
 type OutputEnvelop struct {
+	// ID identifies the message. It holds the client supplied ID, or a generated ID when the client did not supply one,
+	// which allows receivers to detect duplicates.
+	ID string `json:"id"`
+
+	// Channel is the channel the message was published to, and Seq the message's sequence number within the channel.
+	// Sequence numbers increase by one with each message, so a receiver which sees a sequence number more than one
+	// above the last one it saw has missed messages, and can fetch them with a FetchEnvelop.
+	Channel string `json:"channel"`
+	Seq     int64  `json:"seq"`
+
 	Type     int             `json:"type"`
 	Data     json.RawMessage `json:"data"`
 	Received int64           `json:"received"`
+
+	// Ack is set when the recipient must acknowledge the message by sending an AckEnvelop with the message's ID.
+	Ack bool `json:"ack,omitempty"`
+
+	// Trace holds the W3C trace context of the publish which sent the message, which allows receivers and any
+	// server-initiated follow up messages to link their traces to it.
+	Trace map[string]string `json:"trace,omitempty"`
+
+	// ExpiresAt is the time at which the message expires in Unix milliseconds, if it expires.
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
 }
 
 // Encode encodes the OutputEnvelop as JSON. The output is suitable for sending over the wire.
 func (e *OutputEnvelop) Encode() ([]byte, error) {
 	return json.Marshal(e)
 }
+
+// AckEnvelop defines the structure of the acknowledgements sent over the WebSocket connection for messages which were
+// received with the Ack flag set. The message is routed by its "message" key.
+type AckEnvelop struct {
+	Message string `json:"message"`
+	ID      string `json:"id"`
+}
+
+// Decode decodes and populates the AckEnvelop from the provided bytes.
+func (e *AckEnvelop) Decode(data []byte) (*AckEnvelop, error) {
+	err := json.Unmarshal(data, e)
+	return e, err
+}
+
+// FetchEnvelop defines the structure of the requests sent over the WebSocket connection to fetch the messages of a
+// channel with sequence numbers From to To, inclusive. The message is routed by its "message" key.
+type FetchEnvelop struct {
+	Message string `json:"message"`
+	Channel string `json:"channel"`
+	From    int64  `json:"from"`
+	To      int64  `json:"to"`
+}
+
+// Decode decodes and populates the FetchEnvelop from the provided bytes.
+func (e *FetchEnvelop) Decode(data []byte) (*FetchEnvelop, error) {
+	err := json.Unmarshal(data, e)
+	return e, err
+}
+
+// FetchedEnvelop defines the structure of the reply sent over the WebSocket connection after the messages requested
+// with a FetchEnvelop were sent. Missing holds the sequence numbers of the requested messages which are no longer
+// available, so the receiver can stop waiting for them. Filtered holds the sequence numbers of the messages which
+// were skipped as they do not match the filter of the receiver's subscription, so they are not mistaken for gaps.
+type FetchedEnvelop struct {
+	Message  string  `json:"message"`
+	Channel  string  `json:"channel"`
+	From     int64   `json:"from"`
+	To       int64   `json:"to"`
+	Missing  []int64 `json:"missing"`
+	Filtered []int64 `json:"filtered,omitempty"`
+}
+
+// Encode encodes the FetchedEnvelop as JSON. The output is suitable for sending over the wire.
+func (e *FetchedEnvelop) Encode() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// SubscribeEnvelop defines the structure of the requests sent over the WebSocket connection to subscribe to, or
+// unsubscribe from, a channel. The message is routed by its "message" key, which is either "subscribe" or
+// "unsubscribe". Filter optionally holds a filter expression, so that only the messages whose data matches it are
+// received, see package filter.
+type SubscribeEnvelop struct {
+	Message string `json:"message"`
+	Channel string `json:"channel"`
+	Filter  string `json:"filter,omitempty"`
+}
+
+// Decode decodes and populates the SubscribeEnvelop from the provided bytes.
+func (e *SubscribeEnvelop) Decode(data []byte) (*SubscribeEnvelop, error) {
+	err := json.Unmarshal(data, e)
+	return e, err
+}
+
+// SubscriptionEnvelop defines the structure of the reply sent over the WebSocket connection to a SubscribeEnvelop. The
+// Message is "subscribed" or "unsubscribed" when the request succeeded, and "denied" when the channel policy does not
+// allow the connection to subscribe to the channel.
+type SubscriptionEnvelop struct {
+	Message string `json:"message"`
+	Channel string `json:"channel"`
+}
+
+// Encode encodes the SubscriptionEnvelop as JSON. The output is suitable for sending over the wire.
+func (e *SubscriptionEnvelop) Encode() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// CancelEnvelop defines the structure of the requests sent over the WebSocket connection to cancel the scheduled
+// message with the provided ID. The message is routed by its "message" key, which is "cancel".
+type CancelEnvelop struct {
+	Message string `json:"message"`
+	ID      string `json:"id"`
+}
+
+// Decode decodes and populates the CancelEnvelop from the provided bytes.
+func (e *CancelEnvelop) Decode(data []byte) (*CancelEnvelop, error) {
+	err := json.Unmarshal(data, e)
+	return e, err
+}
+
+// CancellationEnvelop defines the structure of the reply sent over the WebSocket connection to a CancelEnvelop. The
+// Message is "canceled" when the scheduled message was canceled, "unknown" when no message with the ID is scheduled,
+// for example as it was already published, and "denied" when the message was scheduled by another user or the channel
+// policy does not allow the connection to publish to the channel of the message.
+type CancellationEnvelop struct {
+	Message string `json:"message"`
+	ID      string `json:"id"`
+}
+
+// Encode encodes the CancellationEnvelop as JSON. The output is suitable for sending over the wire.
+func (e *CancellationEnvelop) Encode() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// Codes of the ClosingEnvelop for the connections closed by the server itself. Other codes may be supplied by the
+// operator closing a connection.
+const (
+	// CloseKicked is the code of connections closed by an operator.
+	CloseKicked = "kicked"
+
+	// CloseReplaced is the code of connections closed as their user opened a newer connection beyond its limit.
+	CloseReplaced = "replaced"
+)
+
+// ClosingEnvelop defines the structure of the notice sent over the WebSocket connection right before the server closes
+// it, which allows the client to tell a server-initiated close from a network failure, and to decide whether to
+// reconnect. The Message is always "server.closing", and Code holds a machine readable reason such as CloseKicked.
+type ClosingEnvelop struct {
+	Message string `json:"message"`
+	Code    string `json:"code"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Encode encodes the ClosingEnvelop as JSON. The output is suitable for sending over the wire.
+func (e *ClosingEnvelop) Encode() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// ValidateID checks that the client supplied message ID can be used to build keys. IDs must not be longer than
+// MaxIDLength and may only hold ASCII letters, digits, '.', ':', '_' and '-'.
+func ValidateID(id string) error {
+	if id == "" || len(id) > MaxIDLength {
+		return ErrInvalidID
+	}
+
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == ':', r == '_', r == '-':
+		default:
+			return ErrInvalidID
+		}
+	}
+
+	return nil
+}
+
+// NewMessageID returns a random message ID for messages published without a client supplied ID.
+func NewMessageID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
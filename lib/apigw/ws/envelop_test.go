@@ -0,0 +1,51 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ws
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want error
+	}{
+		{"4f2a-b.c_d:1", nil},
+		{NewMessageID(), nil},
+		{strings.Repeat("a", MaxIDLength), nil},
+		{"", ErrInvalidID},
+		{strings.Repeat("a", MaxIDLength+1), ErrInvalidID},
+		{"a}b", ErrInvalidID},
+		{"{tag}", ErrInvalidID},
+		{"a b", ErrInvalidID},
+		{"é", ErrInvalidID},
+	}
+
+	for _, tt := range tests {
+		if got := ValidateID(tt.id); got != tt.want {
+			t.Errorf("ValidateID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package channel provides ordered delivery of messages within a channel. Each message published to a channel is
+// assigned the next sequence number of the channel and kept in the channel's history, so that receivers which detect a
+// gap in the sequence numbers can fetch the messages they missed.
+package channel
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// Default is the channel of messages published without a channel.
+const Default = "default"
+
+// MaxNameLength is the maximum length of a channel name.
+const MaxNameLength = 128
+
+// ErrInvalidName is returned by Validate for channel names which can not be used.
+var ErrInvalidName = errors.New("invalid channel name")
+
+// Validate checks that the provided channel name can be used to build keys. Names must not be longer than
+// MaxNameLength, and must not hold braces, which would break the hash tag of the keys, or white space.
+func Validate(name string) error {
+	if name == "" || len(name) > MaxNameLength || strings.ContainsAny(name, "{} \t\r\n") {
+		return ErrInvalidName
+	}
+
+	return nil
+}
+
+// History assigns sequence numbers to the messages of a channel and keeps the most recent messages in Redis.
+type History struct {
+	redis redis.Client
+	opts  Options
+}
+
+// NewHistory creates a new History. Zero values in the provided Options are replaced by the values of DefaultOptions.
+func NewHistory(client redis.Client, opts Options) *History {
+	defaults := DefaultOptions()
+	if opts.HistorySize <= 0 {
+		opts.HistorySize = defaults.HistorySize
+	}
+
+	if opts.HistoryRetention <= 0 {
+		opts.HistoryRetention = defaults.HistoryRetention
+	}
+
+	return &History{redis: client, opts: opts}
+}
+
+// Next returns the next sequence number of the channel of the tenant with the provided Keyspace. Sequence numbers start
+// at 1 and are never reused, even if the message they were assigned to is never appended.
+func (h *History) Next(ctx context.Context, ks redis.Keyspace, channel string) (int64, error) {
+	var seq int64
+	err := redis.Do(ctx, h.redis, "INCR", radix.Cmd(&seq, "INCR", ks.ChannelKey(channel, "seq")))
+	return seq, err
+}
+
+// Append adds the encoded message with the provided sequence number to the history of the channel, and drops the
+// oldest messages beyond the history size.
+func (h *History) Append(ctx context.Context, ks redis.Keyspace, channel string, seq int64, payload []byte) error {
+	key := ks.ChannelKey(channel, "history")
+	err := redis.Do(ctx, h.redis, "ZADD", radix.FlatCmd(nil, "ZADD", key, seq, payload))
+	if err != nil {
+		return err
+	}
+
+	err = redis.Do(ctx, h.redis, "ZREMRANGEBYRANK",
+		radix.Cmd(nil, "ZREMRANGEBYRANK", key, "0", strconv.Itoa(-h.opts.HistorySize-1)))
+	if err != nil {
+		return err
+	}
+
+	retention := strconv.FormatInt(int64(h.opts.HistoryRetention/time.Millisecond), 10)
+	return redis.Do(ctx, h.redis, "PEXPIRE", radix.Cmd(nil, "PEXPIRE", key, retention))
+}
+
+// Entry is a message kept in the history of a channel.
+type Entry struct {
+	Seq     int64
+	Payload []byte
+}
+
+// Range returns the messages of the channel with sequence numbers from and to, inclusive, in order. Messages which
+// were dropped from the history, or never appended, are missing from the result.
+func (h *History) Range(ctx context.Context, ks redis.Keyspace, channel string, from, to int64) ([]Entry, error) {
+	// WITHSCORES replies with each member followed by its score.
+	var reply []string
+	err := redis.DoRead(ctx, h.redis, "ZRANGEBYSCORE", radix.Cmd(&reply, "ZRANGEBYSCORE",
+		ks.ChannelKey(channel, "history"), strconv.FormatInt(from, 10), strconv.FormatInt(to, 10), "WITHSCORES"))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(reply)/2)
+	for i := 0; i+1 < len(reply); i += 2 {
+		seq, err := strconv.ParseInt(reply[i+1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, Entry{Seq: seq, Payload: []byte(reply[i])})
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,77 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package channel
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Environment variables read by OptionsFromEnv.
+const (
+	EnvHistorySize      = "CHANNEL_HISTORY_SIZE"
+	EnvHistoryRetention = "CHANNEL_HISTORY_RETENTION"
+)
+
+// Options configures the History.
+type Options struct {
+	// HistorySize is the number of most recent messages kept for each channel. Older messages can no longer be fetched.
+	HistorySize int
+
+	// HistoryRetention is how long the history of a channel is kept after its last message.
+	HistoryRetention time.Duration
+}
+
+// DefaultOptions returns the Options used when no environment variables are set.
+func DefaultOptions() Options {
+	return Options{
+		HistorySize:      1000,
+		HistoryRetention: time.Hour,
+	}
+}
+
+// OptionsFromEnv returns DefaultOptions overridden by any of the CHANNEL_* environment variables which are set.
+func OptionsFromEnv() (Options, error) {
+	opts := DefaultOptions()
+	if v := os.Getenv(EnvHistorySize); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return opts, fmt.Errorf("invalid %s %q: must be a positive integer", EnvHistorySize, v)
+		}
+
+		opts.HistorySize = n
+	}
+
+	if v := os.Getenv(EnvHistoryRetention); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			return opts, fmt.Errorf("invalid %s %q: must be a duration of at least 1s", EnvHistoryRetention, v)
+		}
+
+		opts.HistoryRetention = d
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,92 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package channel
+
+import (
+	"errors"
+	"strings"
+)
+
+// Wildcards of channel patterns. Channel names are split into segments at dots. The SingleWildcard matches exactly one
+// segment, and the MultiWildcard, which may only be the last segment of a pattern, matches any number of segments,
+// including none. For example, "orders.*" matches "orders.42" but not "orders.42.items", and "devices.eu.#" matches
+// "devices.eu", "devices.eu.42" and "devices.eu.42.battery".
+const (
+	SingleWildcard = "*"
+	MultiWildcard  = "#"
+)
+
+// ErrInvalidPattern is returned by ValidatePattern for patterns with wildcards which are not whole segments, or with a
+// MultiWildcard which is not the last segment.
+var ErrInvalidPattern = errors.New("invalid channel pattern")
+
+// IsPattern reports whether the channel name holds wildcards. Messages can not be published to patterns.
+func IsPattern(name string) bool {
+	return strings.ContainsAny(name, SingleWildcard+MultiWildcard)
+}
+
+// ValidatePattern checks that the provided channel name or pattern can be subscribed to.
+func ValidatePattern(pattern string) error {
+	if err := Validate(pattern); err != nil {
+		return err
+	}
+
+	segments := strings.Split(pattern, ".")
+	for i, segment := range segments {
+		switch {
+		case segment == SingleWildcard, segment == MultiWildcard && i == len(segments)-1:
+		case IsPattern(segment):
+			return ErrInvalidPattern
+		}
+	}
+
+	return nil
+}
+
+// Match reports whether the pattern matches the channel name. A name without wildcards only matches itself.
+func Match(pattern, name string) bool {
+	p, n := strings.Split(pattern, "."), strings.Split(name, ".")
+	for i, segment := range p {
+		if segment == MultiWildcard {
+			return true
+		}
+
+		if i >= len(n) || (segment != SingleWildcard && segment != n[i]) {
+			return false
+		}
+	}
+
+	return len(p) == len(n)
+}
+
+// prefix returns the literal prefix of the pattern, which are the segments before its first wildcard.
+func prefix(pattern string) string {
+	segments := strings.Split(pattern, ".")
+	for i, segment := range segments {
+		if segment == SingleWildcard || segment == MultiWildcard {
+			return strings.Join(segments[:i], ".")
+		}
+	}
+
+	return pattern
+}
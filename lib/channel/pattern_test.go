@@ -0,0 +1,145 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package channel
+
+import "testing"
+
+func TestIsPattern(t *testing.T) {
+	tests := map[string]bool{
+		"orders":       false,
+		"orders.42":    false,
+		"orders..42":   false,
+		"orders.*":     true,
+		"devices.eu.#": true,
+		"orders.4*":    true,
+	}
+
+	for name, want := range tests {
+		if got := IsPattern(name); got != want {
+			t.Errorf("IsPattern(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    error
+	}{
+		{"orders", nil},
+		{"orders.42", nil},
+		{"orders.*", nil},
+		{"*.42.*", nil},
+		{"devices.eu.#", nil},
+		{"#", nil},
+		{"orders..42", nil},
+		{"devices.#.battery", ErrInvalidPattern},
+		{"#.battery", ErrInvalidPattern},
+		{"orders.4*", ErrInvalidPattern},
+		{"orders.#42", ErrInvalidPattern},
+		{"orders.**", ErrInvalidPattern},
+		{"", ErrInvalidName},
+		{"orders.{42}", ErrInvalidName},
+		{"orders 42", ErrInvalidName},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if got := ValidatePattern(tt.pattern); got != tt.want {
+				t.Errorf("ValidatePattern(%q) = %v, want %v", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		// Names without wildcards only match themselves.
+		{"orders", "orders", true},
+		{"orders.42", "orders.42", true},
+		{"orders", "orders.42", false},
+		{"orders.42", "orders", false},
+		{"orders.42", "orders.43", false},
+		{"orders", "order", false},
+
+		{"orders.*", "orders.42", true},
+		{"orders.*", "orders", false},
+		{"orders.*", "orders.42.items", false},
+		{"*.42", "orders.42", true},
+		{"*.*", "orders.42", true},
+		{"orders.*.items", "orders.42.items", true},
+		{"orders.*.items", "orders.42.lines", false},
+
+		// The MultiWildcard at the end matches any number of segments, including none.
+		{"devices.eu.#", "devices.eu", true},
+		{"devices.eu.#", "devices.eu.42", true},
+		{"devices.eu.#", "devices.eu.42.battery", true},
+		{"devices.eu.#", "devices", false},
+		{"devices.eu.#", "devices.europe", false},
+		{"devices.*.#", "devices.eu.42", true},
+		{"devices.*.#", "devices", false},
+		{"#", "orders.42", true},
+
+		// Empty segments are segments like any other.
+		{"orders..42", "orders..42", true},
+		{"orders.*.42", "orders..42", true},
+		{"orders.*", "orders.", true},
+		{"orders.*", "orders..", false},
+		{"orders.#", "orders..", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			if got := Match(tt.pattern, tt.name); got != tt.want {
+				t.Errorf("Match(%q, %q) = %t, want %t", tt.pattern, tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"orders.42", "orders.42"},
+		{"orders.*", "orders"},
+		{"orders.*.items", "orders"},
+		{"devices.eu.#", "devices.eu"},
+		{"*.42", ""},
+		{"#", ""},
+		{"orders..*", "orders."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if got := prefix(tt.pattern); got != tt.want {
+				t.Errorf("prefix(%q) = %q, want %q", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package channel
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// subscriptionsTTL is how long the channels a connection subscribed to are kept after its last subscription. Amazon
+// API Gateway closes connections after 2 hours.
+const subscriptionsTTL = 3 * time.Hour
+
+// indexScript counts the subscribers of a pattern in the pattern index. The pattern is added to the set of patterns
+// with its literal prefix while it has subscribers, and removed from it once the last subscriber is gone. KEYS[1] is
+// the set of the prefix and KEYS[2] the hash of the counts, ARGV[1] the pattern and ARGV[2] the change of its count.
+var indexScript = radix.NewEvalScript(2, `
+local n = redis.call("HINCRBY", KEYS[2], ARGV[1], ARGV[2])
+if n <= 0 then
+	redis.call("HDEL", KEYS[2], ARGV[1])
+	redis.call("SREM", KEYS[1], ARGV[1])
+else
+	redis.call("SADD", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// Subscriptions keeps the subscribers of each channel and pattern. The subscribers of a channel are kept in a set of
+// the channel, and the channels of a connection in a set of the connection, so that its subscriptions can be removed
+// once it is closed. Every connection receives the messages of the Default channel without subscribing to it. The
+// filter expressions of the subscriptions of a channel are kept in a hash of the channel.
+//
+// Patterns are indexed by their literal prefix, the segments before the first wildcard. A message published to
+// "devices.eu.42" is matched against the patterns with the prefixes "", "devices", "devices.eu" and "devices.eu.42"
+// only, so resolving the pattern subscribers of a channel does not scan unrelated patterns. All sets of the index
+// share a hash tag, which allows them to be read with a single SUNION in cluster mode.
+type Subscriptions struct {
+	redis redis.Client
+}
+
+// Subscriber is a connection subscribed to a channel, with the filter expression of its subscription, if any. See
+// package filter.
+type Subscriber struct {
+	ConnectionID string
+	Filter       string
+}
+
+// NewSubscriptions creates a new Subscriptions.
+func NewSubscriptions(client redis.Client) *Subscriptions {
+	return &Subscriptions{redis: client}
+}
+
+// Subscribe adds the connection to the subscribers of the channel or pattern. The connection only receives the
+// messages whose data matches the filter expression, unless it is empty. Subscribing again replaces the filter.
+func (s *Subscriptions) Subscribe(ctx context.Context, ks redis.Keyspace, channel, connectionID,
+	filter string) error {
+	var err error
+	if filter == "" {
+		err = redis.Do(ctx, s.redis, "HDEL", radix.Cmd(nil, "HDEL", ks.ChannelKey(channel, "filters"), connectionID))
+	} else {
+		err = redis.Do(ctx, s.redis, "HSET",
+			radix.Cmd(nil, "HSET", ks.ChannelKey(channel, "filters"), connectionID, filter))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var added int
+	err = redis.Do(ctx, s.redis, "SADD",
+		radix.Cmd(&added, "SADD", ks.ChannelKey(channel, "subscribers"), connectionID))
+	if err != nil {
+		return err
+	}
+
+	if added > 0 && IsPattern(channel) {
+		if err := s.index(ctx, ks, channel, 1); err != nil {
+			return err
+		}
+	}
+
+	key := ks.ConnectionKey(connectionID, "channels")
+	if err := redis.Do(ctx, s.redis, "SADD", radix.Cmd(nil, "SADD", key, channel)); err != nil {
+		return err
+	}
+
+	ttl := strconv.FormatInt(subscriptionsTTL.Milliseconds(), 10)
+	return redis.Do(ctx, s.redis, "PEXPIRE", radix.Cmd(nil, "PEXPIRE", key, ttl))
+}
+
+// Unsubscribe removes the connection from the subscribers of the channel or pattern.
+func (s *Subscriptions) Unsubscribe(ctx context.Context, ks redis.Keyspace, channel, connectionID string) error {
+	if err := s.unsubscribe(ctx, ks, channel, connectionID); err != nil {
+		return err
+	}
+
+	key := ks.ConnectionKey(connectionID, "channels")
+	return redis.Do(ctx, s.redis, "SREM", radix.Cmd(nil, "SREM", key, channel))
+}
+
+// Subscribers returns the connections subscribed to the channel itself. The subscribers are read from a replica when
+// one is configured.
+func (s *Subscriptions) Subscribers(ctx context.Context, ks redis.Keyspace, channel string) ([]Subscriber, error) {
+	var ids []string
+	key := ks.ChannelKey(channel, "subscribers")
+	if err := redis.DoRead(ctx, s.redis, "SMEMBERS", radix.Cmd(&ids, "SMEMBERS", key)); err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	filters := make(map[string]string)
+	key = ks.ChannelKey(channel, "filters")
+	if err := redis.DoRead(ctx, s.redis, "HGETALL", radix.Cmd(&filters, "HGETALL", key)); err != nil {
+		return nil, err
+	}
+
+	subscribers := make([]Subscriber, len(ids))
+	for i, id := range ids {
+		subscribers[i] = Subscriber{ConnectionID: id, Filter: filters[id]}
+	}
+
+	return subscribers, nil
+}
+
+// PatternSubscribers returns the connections subscribed to a pattern which matches the channel. A connection
+// subscribed to several matching patterns is only returned once, with the filters of its subscriptions combined, see
+// Merge. The subscribers are read from a replica when one is configured.
+func (s *Subscriptions) PatternSubscribers(ctx context.Context, ks redis.Keyspace,
+	channel string) ([]Subscriber, error) {
+	segments := strings.Split(channel, ".")
+	keys := make([]string, 0, len(segments)+1)
+	keys = append(keys, ks.Key("patterns", "prefix", ""))
+	for i := range segments {
+		keys = append(keys, ks.Key("patterns", "prefix", strings.Join(segments[:i+1], ".")))
+	}
+
+	var candidates []string
+	err := redis.DoRead(ctx, s.redis, "SUNION", radix.Cmd(&candidates, "SUNION", keys...))
+	if err != nil {
+		return nil, err
+	}
+
+	var subscribers []Subscriber
+	for _, pattern := range candidates {
+		if !Match(pattern, channel) {
+			continue
+		}
+
+		matched, err := s.Subscribers(ctx, ks, pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		subscribers = Merge(subscribers, matched)
+	}
+
+	return subscribers, nil
+}
+
+// Subscription returns the subscription of the connection to the channel, with the filters of its subscriptions to
+// the channel itself and to the patterns matching it combined, see Merge. It reports false if the connection is not
+// subscribed to the channel. Every connection is subscribed to the Default channel, without a filter. The
+// subscriptions are read from the primary, so that a connection can rely on a subscription it just made.
+func (s *Subscriptions) Subscription(ctx context.Context, ks redis.Keyspace, channel,
+	connectionID string) (Subscriber, bool, error) {
+	if channel == Default {
+		return Subscriber{ConnectionID: connectionID}, true, nil
+	}
+
+	var channels []string
+	key := ks.ConnectionKey(connectionID, "channels")
+	if err := redis.Do(ctx, s.redis, "SMEMBERS", radix.Cmd(&channels, "SMEMBERS", key)); err != nil {
+		return Subscriber{}, false, err
+	}
+
+	var subscribers []Subscriber
+	for _, subscribed := range channels {
+		if subscribed != channel && !(IsPattern(subscribed) && Match(subscribed, channel)) {
+			continue
+		}
+
+		var expr string
+		key := ks.ChannelKey(subscribed, "filters")
+		if err := redis.Do(ctx, s.redis, "HGET", radix.Cmd(&expr, "HGET", key, connectionID)); err != nil {
+			return Subscriber{}, false, err
+		}
+
+		subscribers = Merge(subscribers, []Subscriber{{ConnectionID: connectionID, Filter: expr}})
+	}
+
+	if len(subscribers) == 0 {
+		return Subscriber{}, false, nil
+	}
+
+	return subscribers[0], true, nil
+}
+
+// Merge adds the subscribers to dst, which it returns. A connection which is already in dst is not added again, and
+// its filter is combined with the new one, so that it receives the messages which match either subscription.
+func Merge(dst []Subscriber, subscribers []Subscriber) []Subscriber {
+	index := make(map[string]int, len(dst))
+	for i, sub := range dst {
+		index[sub.ConnectionID] = i
+	}
+
+	for _, sub := range subscribers {
+		i, ok := index[sub.ConnectionID]
+		switch {
+		case !ok:
+			index[sub.ConnectionID] = len(dst)
+			dst = append(dst, sub)
+		case dst[i].Filter == "" || sub.Filter == "":
+			dst[i].Filter = ""
+		case dst[i].Filter != sub.Filter:
+			dst[i].Filter = "(" + dst[i].Filter + ") or (" + sub.Filter + ")"
+		}
+	}
+
+	return dst
+}
+
+// Remove removes the connection from the subscribers of all channels and patterns it subscribed to.
+func (s *Subscriptions) Remove(ctx context.Context, ks redis.Keyspace, connectionID string) error {
+	var channels []string
+	key := ks.ConnectionKey(connectionID, "channels")
+	if err := redis.Do(ctx, s.redis, "SMEMBERS", radix.Cmd(&channels, "SMEMBERS", key)); err != nil {
+		return err
+	}
+
+	for _, channel := range channels {
+		if err := s.unsubscribe(ctx, ks, channel, connectionID); err != nil {
+			return err
+		}
+	}
+
+	return redis.Do(ctx, s.redis, "DEL", radix.Cmd(nil, "DEL", key))
+}
+
+// unsubscribe removes the connection from the subscribers of the channel or pattern, and removes the pattern from the
+// index once it has no subscribers left.
+func (s *Subscriptions) unsubscribe(ctx context.Context, ks redis.Keyspace, channel, connectionID string) error {
+	var removed int
+	err := redis.Do(ctx, s.redis, "SREM",
+		radix.Cmd(&removed, "SREM", ks.ChannelKey(channel, "subscribers"), connectionID))
+	if err != nil || removed == 0 {
+		return err
+	}
+
+	err = redis.Do(ctx, s.redis, "HDEL", radix.Cmd(nil, "HDEL", ks.ChannelKey(channel, "filters"), connectionID))
+	if err != nil || !IsPattern(channel) {
+		return err
+	}
+
+	return s.index(ctx, ks, channel, -1)
+}
+
+// index changes the count of subscribers of the pattern in the pattern index by delta.
+func (s *Subscriptions) index(ctx context.Context, ks redis.Keyspace, pattern string, delta int) error {
+	return redis.Do(ctx, s.redis, "EVALSHA", indexScript.Cmd(nil, ks.Key("patterns", "prefix", prefix(pattern)),
+		ks.Key("patterns", "counts"), pattern, strconv.Itoa(delta)))
+}
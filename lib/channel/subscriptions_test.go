@@ -0,0 +1,109 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package channel
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// store is an in-memory Redis client implementing the commands used to read subscriptions.
+type store struct {
+	sets   map[string][]string
+	hashes map[string]map[string]string
+}
+
+func (s *store) Do(a radix.Action) error { return a.Run(radix.Stub("", "", s.exec)) }
+
+func (s *store) DoRead(a radix.Action) error { return s.Do(a) }
+
+func (s *store) Close() error { return nil }
+
+func (s *store) exec(args []string) interface{} {
+	switch args[0] {
+	case "SMEMBERS":
+		return append([]string{}, s.sets[args[1]]...)
+	case "HGET":
+		if v, ok := s.hashes[args[1]][args[2]]; ok {
+			return v
+		}
+
+		return nil
+	}
+
+	return errors.New("ERR unknown command " + args[0])
+}
+
+func TestSubscription(t *testing.T) {
+	ks := redis.Tenant("acme")
+	client := &store{
+		sets: map[string][]string{
+			ks.ConnectionKey("conn1", "channels"): {"devices.eu.42", "devices.*.42", "alerts"},
+			ks.ConnectionKey("conn2", "channels"): {"devices.#", "devices.eu.*"},
+		},
+		hashes: map[string]map[string]string{
+			ks.ChannelKey("devices.eu.42", "filters"): {"conn1": "level >= warn"},
+			ks.ChannelKey("devices.*.42", "filters"):  {"conn1": "region == eu"},
+			ks.ChannelKey("devices.eu.*", "filters"):  {"conn2": "level == error"},
+		},
+	}
+
+	tests := []struct {
+		name, channel, connection string
+		subscribed                bool
+		filter                    string
+	}{
+		{"channel and pattern", "devices.eu.42", "conn1", true, "(level >= warn) or (region == eu)"},
+		{"pattern only", "devices.us.42", "conn1", true, "region == eu"},
+		{"without filter", "alerts", "conn1", true, ""},
+		{"unfiltered pattern wins", "devices.eu.7", "conn2", true, ""},
+		{"not subscribed", "devices.us.7", "conn1", false, ""},
+		{"default channel", Default, "conn3", true, ""},
+		{"no subscriptions", "alerts", "conn3", false, ""},
+	}
+
+	subs := NewSubscriptions(client)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, ok, err := subs.Subscription(context.Background(), ks, tt.channel, tt.connection)
+			if err != nil {
+				t.Fatalf("Subscription returned error: %v", err)
+			}
+
+			want := Subscriber{}
+			if tt.subscribed {
+				want = Subscriber{ConnectionID: tt.connection, Filter: tt.filter}
+			}
+
+			if ok != tt.subscribed || !reflect.DeepEqual(sub, want) {
+				t.Errorf("Subscription(%q, %q) = %+v, %v, want %+v, %v", tt.channel, tt.connection, sub, ok, want,
+					tt.subscribed)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package fanout provides a bounded worker pool which sends a message to many recipients concurrently.
+package fanout
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSkipped is returned by a SendFunc which did not send the message to the recipient, for example as the message
+// expired. Skipped recipients are counted as neither sent nor failed.
+var ErrSkipped = errors.New("recipient skipped")
+
+// SendFunc sends the message to a single recipient.
+type SendFunc func(ctx context.Context, recipient string) error
+
+// Limiter paces the sends of the Engine. Wait blocks until the next send may be made, or returns an error if the
+// context is done first.
+type Limiter interface {
+	Wait(ctx context.Context) error
+}
+
+// Result summarizes a run of the Engine.
+type Result struct {
+	// Recipients is the number of recipients of the run, Workers the number of workers started for them.
+	Recipients int
+	Workers    int
+
+	// Sent and Failed count the recipients for which the SendFunc returned nil and an error other than ErrSkipped.
+	// Recipients which were not attempted are counted by neither, and returned in Remaining.
+	Sent   int
+	Failed int
+
+	// Skipped counts the recipients for which the SendFunc returned ErrSkipped.
+	Skipped int
+
+	// Remaining holds the recipients which were not attempted because the deadline of the context, less the deadline
+	// margin, was reached or the context was canceled. The caller is responsible for handing them off.
+	Remaining []string
+
+	// Throttled is the total time the workers waited for the Limiter.
+	Throttled time.Duration
+
+	Duration time.Duration
+}
+
+// Throughput returns the number of attempted sends per second.
+func (r Result) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+
+	return float64(r.Sent+r.Failed) / r.Duration.Seconds()
+}
+
+// Engine runs a SendFunc for each recipient of a message on a pool of workers. The pool is sized by the number of
+// recipients, so small fan-outs do not pay for workers they do not need.
+type Engine struct {
+	opts     Options
+	inFlight chan struct{}
+}
+
+// New creates a new Engine. Zero values in the provided Options are replaced by the values of DefaultOptions.
+func New(opts Options) *Engine {
+	defaults := DefaultOptions()
+	if opts.MaxWorkers <= 0 {
+		opts.MaxWorkers = defaults.MaxWorkers
+	}
+
+	if opts.RecipientsPerWorker <= 0 {
+		opts.RecipientsPerWorker = defaults.RecipientsPerWorker
+	}
+
+	if opts.DeadlineMargin <= 0 {
+		opts.DeadlineMargin = defaults.DeadlineMargin
+	}
+
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = defaults.MaxInFlight
+	}
+
+	return &Engine{opts: opts, inFlight: make(chan struct{}, opts.MaxInFlight)}
+}
+
+// Wait blocks until the Limiter allows another send, for example to retry a send within a run, so that retries are
+// paced like the first attempts. Waiting ends at the deadline of the context less the deadline margin, as in Run. It
+// returns nil right away when there is no Limiter.
+func (e *Engine) Wait(ctx context.Context) error {
+	if e.opts.Limiter == nil {
+		return nil
+	}
+
+	if cutoff := e.cutoff(ctx); !cutoff.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, cutoff)
+		defer cancel()
+	}
+
+	return e.opts.Limiter.Wait(ctx)
+}
+
+// cutoff returns the time at which the Engine stops dispatching recipients, the deadline of the context less the
+// deadline margin, or the zero time if the context has no deadline. The AWS Lambda runtime sets the deadline of the
+// context to the deadline of the invocation.
+func (e *Engine) cutoff(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline.Add(-e.opts.DeadlineMargin)
+	}
+
+	return time.Time{}
+}
+
+// Workers returns the number of workers started for the provided number of recipients.
+func (e *Engine) Workers(recipients int) int {
+	n := (recipients + e.opts.RecipientsPerWorker - 1) / e.opts.RecipientsPerWorker
+	if n > e.opts.MaxWorkers {
+		n = e.opts.MaxWorkers
+	}
+
+	return n
+}
+
+// Run calls send for each recipient and returns once all recipients were attempted, the deadline of the context less
+// the deadline margin is reached, or the context is canceled. Sends which are in flight when the deadline margin is
+// reached are completed, which is what the margin is reserved for. Errors returned by send are counted, handling them
+// is the responsibility of send.
+func (e *Engine) Run(ctx context.Context, recipients []string, send SendFunc) Result {
+	res := Result{Recipients: len(recipients), Workers: e.Workers(len(recipients))}
+	start := time.Now()
+
+	// Stop dispatching recipients once the cutoff is reached.
+	cutoff := e.cutoff(ctx)
+
+	stopped := func() bool {
+		return ctx.Err() != nil || (!cutoff.IsZero() && time.Now().After(cutoff))
+	}
+
+	// Waiting for the Limiter ends at the cutoff as well, and the recipient is then returned as remaining.
+	paced := ctx
+	if !cutoff.IsZero() {
+		var cancel context.CancelFunc
+		paced, cancel = context.WithDeadline(ctx, cutoff)
+		defer cancel()
+	}
+
+	var mu sync.Mutex
+	var skipped []string
+	var next, sent, failed, skippedSends, throttled int64
+	var wg sync.WaitGroup
+	for i := 0; i < res.Workers; i++ {
+		wg.Add(1)
+
+		// Run the go routine until the cutoff is reached or there is no more work to process.
+		go func() {
+			defer wg.Done()
+			for !stopped() {
+				i := int(atomic.AddInt64(&next, 1) - 1)
+				if i >= len(recipients) {
+					return
+				}
+
+				// The recipient was claimed by this worker, so it must be returned as remaining if it is not
+				// attempted.
+				if e.opts.Limiter != nil {
+					waited := time.Now()
+					err := e.opts.Limiter.Wait(paced)
+					atomic.AddInt64(&throttled, int64(time.Since(waited)))
+					if err != nil {
+						mu.Lock()
+						skipped = append(skipped, recipients[i])
+						mu.Unlock()
+						return
+					}
+				}
+
+				select {
+				case <-ctx.Done():
+					mu.Lock()
+					skipped = append(skipped, recipients[i])
+					mu.Unlock()
+					return
+				case e.inFlight <- struct{}{}:
+				}
+
+				err := send(ctx, recipients[i])
+				<-e.inFlight
+				switch err {
+				case nil:
+					atomic.AddInt64(&sent, 1)
+				case ErrSkipped:
+					atomic.AddInt64(&skippedSends, 1)
+				default:
+					atomic.AddInt64(&failed, 1)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	if n := int(next); n < len(recipients) {
+		skipped = append(skipped, recipients[n:]...)
+	}
+
+	res.Remaining = skipped
+	res.Sent, res.Failed, res.Skipped = int(sent), int(failed), int(skippedSends)
+	res.Throttled = time.Duration(throttled)
+	res.Duration = time.Since(start)
+	return res
+}
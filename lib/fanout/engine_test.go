@@ -0,0 +1,224 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package fanout
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recipients returns n recipient IDs.
+func recipients(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = "conn" + strconv.Itoa(i)
+	}
+
+	return ids
+}
+
+// blockingLimiter never allows a send, and records the deadline of the contexts it waited on.
+type blockingLimiter struct {
+	mu        sync.Mutex
+	deadlines []time.Time
+}
+
+func (l *blockingLimiter) Wait(ctx context.Context) error {
+	deadline, _ := ctx.Deadline()
+	l.mu.Lock()
+	l.deadlines = append(l.deadlines, deadline)
+	l.mu.Unlock()
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestWorkers(t *testing.T) {
+	e := New(Options{MaxWorkers: 8, RecipientsPerWorker: 4})
+	tests := []struct {
+		recipients int
+		want       int
+	}{
+		{0, 0},
+		{1, 1},
+		{4, 1},
+		{5, 2},
+		{32, 8},
+		{1000, 8},
+	}
+
+	for _, tt := range tests {
+		if got := e.Workers(tt.recipients); got != tt.want {
+			t.Errorf("Workers(%d) = %d, want %d", tt.recipients, got, tt.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	e := New(Options{MaxWorkers: 4, RecipientsPerWorker: 2, MaxInFlight: 2})
+	ids := recipients(20)
+
+	var mu sync.Mutex
+	attempted := make(map[string]int)
+	res := e.Run(context.Background(), ids, func(_ context.Context, recipient string) error {
+		mu.Lock()
+		attempted[recipient]++
+		mu.Unlock()
+
+		i, _ := strconv.Atoi(recipient[len("conn"):])
+		switch i % 4 {
+		case 1:
+			return errors.New("gone")
+		case 2:
+			return ErrSkipped
+		}
+
+		return nil
+	})
+
+	want := Result{Recipients: 20, Workers: 4, Sent: 10, Failed: 5, Skipped: 5}
+	res.Duration = 0
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("Run returned %+v, want %+v", res, want)
+	}
+
+	for _, id := range ids {
+		if attempted[id] != 1 {
+			t.Errorf("%s attempted %d times, want once", id, attempted[id])
+		}
+	}
+}
+
+func TestRunDeadline(t *testing.T) {
+	e := New(Options{MaxWorkers: 1, DeadlineMargin: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second+50*time.Millisecond)
+	defer cancel()
+
+	ids := recipients(100)
+	var attempted []string
+	res := e.Run(ctx, ids, func(_ context.Context, recipient string) error {
+		attempted = append(attempted, recipient)
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+
+	if len(res.Remaining) == 0 || res.Sent != len(attempted) {
+		t.Fatalf("Run returned %d sent and %d remaining, want the run cut off", res.Sent, len(res.Remaining))
+	}
+
+	// Every recipient is either attempted or returned as remaining, so that it can be handed off.
+	if got := append(attempted, res.Remaining...); !reflect.DeepEqual(got, ids) {
+		t.Errorf("Run attempted %v and returned %v as remaining, want all recipients once", attempted, res.Remaining)
+	}
+
+	if ctx.Err() != nil {
+		t.Error("Run returned after the deadline of the context, want it to leave the deadline margin")
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	e := New(Options{MaxWorkers: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	res := e.Run(ctx, recipients(5), func(context.Context, string) error {
+		cancel()
+		return nil
+	})
+
+	if want := recipients(5)[1:]; res.Sent != 1 || !reflect.DeepEqual(res.Remaining, want) {
+		t.Errorf("Run returned %d sent and %v remaining, want 1 and %v", res.Sent, res.Remaining, want)
+	}
+}
+
+func TestRunLimiter(t *testing.T) {
+	limiter := &blockingLimiter{}
+	e := New(Options{MaxWorkers: 2, RecipientsPerWorker: 1, DeadlineMargin: time.Second, Limiter: limiter})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second+50*time.Millisecond)
+	defer cancel()
+
+	ids := recipients(10)
+	res := e.Run(ctx, ids, func(context.Context, string) error {
+		t.Error("Run sent a message the Limiter did not allow")
+		return nil
+	})
+
+	sort.Strings(res.Remaining)
+	if res.Sent != 0 || !reflect.DeepEqual(res.Remaining, ids) {
+		t.Errorf("Run returned %d sent and %v remaining, want all recipients remaining", res.Sent, res.Remaining)
+	}
+
+	if res.Throttled <= 0 {
+		t.Errorf("Run returned %v throttled, want the time spent waiting", res.Throttled)
+	}
+
+	deadline, _ := ctx.Deadline()
+	for _, d := range limiter.deadlines {
+		if !d.Equal(deadline.Add(-time.Second)) {
+			t.Errorf("Limiter waited until %v, want the cutoff %v", d, deadline.Add(-time.Second))
+		}
+	}
+}
+
+func TestWait(t *testing.T) {
+	if err := New(Options{}).Wait(context.Background()); err != nil {
+		t.Errorf("Wait without a Limiter returned %v", err)
+	}
+
+	limiter := &blockingLimiter{}
+	e := New(Options{DeadlineMargin: time.Second, Limiter: limiter})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second+20*time.Millisecond)
+	defer cancel()
+
+	if err := e.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if ctx.Err() != nil {
+		t.Error("Wait returned after the deadline of the context, want it to end at the cutoff")
+	}
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Setenv(EnvMaxWorkers, "16")
+	t.Setenv(EnvDeadlineMargin, "500ms")
+	opts, err := OptionsFromEnv()
+	if err != nil || opts.MaxWorkers != 16 || opts.DeadlineMargin != 500*time.Millisecond {
+		t.Errorf("OptionsFromEnv returned %+v, %v", opts, err)
+	}
+
+	for k, v := range map[string]string{EnvRecipientsPerWorker: "0", EnvMaxInFlight: "many", EnvDeadlineMargin: "-1s"} {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv(k, v)
+			if _, err := OptionsFromEnv(); err == nil {
+				t.Errorf("OptionsFromEnv accepted %s %q", k, v)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package fanout
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Environment variables read by OptionsFromEnv.
+const (
+	EnvMaxWorkers          = "FANOUT_MAX_WORKERS"
+	EnvRecipientsPerWorker = "FANOUT_RECIPIENTS_PER_WORKER"
+	EnvMaxInFlight         = "FANOUT_MAX_IN_FLIGHT"
+	EnvDeadlineMargin      = "FANOUT_DEADLINE_MARGIN"
+)
+
+// Options configures the Engine.
+type Options struct {
+	// MaxWorkers is the maximum number of workers started for a single run.
+	MaxWorkers int
+
+	// RecipientsPerWorker is the number of recipients for which one worker is started, up to MaxWorkers. A run with
+	// fewer recipients than RecipientsPerWorker uses a single worker.
+	RecipientsPerWorker int
+
+	// MaxInFlight caps the number of concurrent sends across all runs of the Engine, which bounds the number of
+	// concurrent HTTP requests to the Amazon API Gateway Management API.
+	MaxInFlight int
+
+	// DeadlineMargin is the time before the deadline of the context at which no more recipients are dispatched. The
+	// margin must cover the sends in flight and handing off the remaining recipients.
+	DeadlineMargin time.Duration
+
+	// Limiter optionally paces the sends across all runs of the Engine, for example to stay under the request quota of
+	// the Amazon API Gateway Management API. It is not read from the environment.
+	Limiter Limiter
+}
+
+// DefaultOptions returns the Options used when no environment variables are set. The number of workers defaults to
+// the number of logical CPUs times a factor of 4, which processes outgoing messages concurrently while limiting the
+// amount of context switching.
+func DefaultOptions() Options {
+	return Options{
+		MaxWorkers:          runtime.NumCPU() * 4,
+		RecipientsPerWorker: 4,
+		MaxInFlight:         runtime.NumCPU() * 4,
+		DeadlineMargin:      2 * time.Second,
+	}
+}
+
+// OptionsFromEnv returns DefaultOptions overridden by any of the FANOUT_* environment variables which are set.
+func OptionsFromEnv() (Options, error) {
+	opts := DefaultOptions()
+	for name, n := range map[string]*int{
+		EnvMaxWorkers:          &opts.MaxWorkers,
+		EnvRecipientsPerWorker: &opts.RecipientsPerWorker,
+		EnvMaxInFlight:         &opts.MaxInFlight,
+	} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed < 1 {
+				return opts, fmt.Errorf("invalid %s %q: must be a positive integer", name, v)
+			}
+
+			*n = parsed
+		}
+	}
+
+	if v := os.Getenv(EnvDeadlineMargin); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return opts, fmt.Errorf("invalid %s %q: must be a positive duration", EnvDeadlineMargin, v)
+		}
+
+		opts.DeadlineMargin = d
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,402 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package filter evaluates the filter expressions of subscriptions against the data of messages. An expression
+// compares fields of the data, which must be a JSON object, with values, and combines comparisons with "and", "or",
+// "not" and parentheses, for example:
+//
+//	severity >= warn and (region == "eu" or region == "uk")
+//
+// Fields are named by their path within the data, such as "device.region". Values are JSON strings, numbers, true,
+// false, null, or bare words, which are taken as strings. Numbers are compared numerically, and strings lexically,
+// except that two severity levels, such as "warn" and "error", are compared by their rank. A comparison with a field
+// which is missing, or holds a value of a different type, never matches, except for "!=".
+package filter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxLength is the maximum length of the expression of a subscription. Expressions combined from several
+// subscriptions may be longer.
+const MaxLength = 1024
+
+// ErrSyntax is wrapped by the errors returned by Parse for expressions which can not be parsed.
+var ErrSyntax = errors.New("invalid filter expression")
+
+// severities ranks the severity levels which are compared by rank rather than lexically.
+var severities = map[string]int{
+	"trace":    0,
+	"debug":    1,
+	"info":     2,
+	"notice":   3,
+	"warn":     4,
+	"warning":  4,
+	"error":    5,
+	"critical": 6,
+	"fatal":    7,
+}
+
+// Filter is a parsed filter expression.
+type Filter struct {
+	source string
+	root   node
+}
+
+// Parse parses the expression.
+func Parse(expr string) (*Filter, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrSyntax, p.tokens[p.pos].text)
+	}
+
+	return &Filter{source: expr, root: root}, nil
+}
+
+// String returns the expression the Filter was parsed from.
+func (f *Filter) String() string {
+	return f.source
+}
+
+// Match reports whether the decoded JSON data matches the expression.
+func (f *Filter) Match(data interface{}) bool {
+	return f.root.eval(data)
+}
+
+// MatchJSON reports whether the encoded JSON data matches the expression. Data which can not be decoded does not
+// match.
+func (f *Filter) MatchJSON(data []byte) bool {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return false
+	}
+
+	return f.Match(v)
+}
+
+// node is a node of the syntax tree of an expression.
+type node interface {
+	eval(data interface{}) bool
+}
+
+type and struct{ left, right node }
+
+func (n and) eval(data interface{}) bool { return n.left.eval(data) && n.right.eval(data) }
+
+type or struct{ left, right node }
+
+func (n or) eval(data interface{}) bool { return n.left.eval(data) || n.right.eval(data) }
+
+type not struct{ operand node }
+
+func (n not) eval(data interface{}) bool { return !n.operand.eval(data) }
+
+// comparison compares the field at path with value.
+type comparison struct {
+	path  []string
+	op    string
+	value interface{}
+}
+
+func (n comparison) eval(data interface{}) bool {
+	for _, key := range n.path {
+		object, ok := data.(map[string]interface{})
+		if !ok {
+			return n.op == "!="
+		}
+
+		if data, ok = object[key]; !ok {
+			return n.op == "!="
+		}
+	}
+
+	c, ok := compare(data, n.value)
+	if !ok {
+		return n.op == "!="
+	}
+
+	switch n.op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+// compare compares two decoded JSON values of the same type. It reports false for values of different types, and for
+// booleans and nulls which are not equal, as they are not ordered.
+func compare(a, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		switch {
+		case !ok:
+			return 0, false
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+
+		return 0, true
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+
+		ra, okA := severities[strings.ToLower(a)]
+		rb, okB := severities[strings.ToLower(b)]
+		if okA && okB {
+			return ra - rb, true
+		}
+
+		return strings.Compare(a, b), true
+	case bool:
+		b, ok := b.(bool)
+		if !ok || a != b {
+			return 0, false
+		}
+
+		return 0, true
+	case nil:
+		return 0, b == nil
+	}
+
+	return 0, false
+}
+
+// token is a lexical token of an expression. Quoted strings are marked, so that they are never taken as keywords.
+type token struct {
+	text   string
+	quoted bool
+}
+
+// tokenize splits the expression into tokens.
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, token{text: string(c)})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+
+				end++
+			}
+
+			if end >= len(expr) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrSyntax)
+			}
+
+			s, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrSyntax, err)
+			}
+
+			tokens = append(tokens, token{text: s, quoted: true})
+			i = end + 1
+		case strings.ContainsRune("=!<>&|", rune(c)):
+			end := i + 1
+			for end < len(expr) && strings.ContainsRune("=!<>&|", rune(expr[end])) {
+				end++
+			}
+
+			tokens = append(tokens, token{text: expr[i:end]})
+			i = end
+		default:
+			end := i
+			for end < len(expr) && !strings.ContainsRune(" \t\r\n()\"=!<>&|", rune(expr[end])) {
+				end++
+			}
+
+			tokens = append(tokens, token{text: expr[i:end]})
+			i = end
+		}
+	}
+
+	return tokens, nil
+}
+
+// parser is a recursive descent parser of the tokens of an expression.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// peek returns the next token without consuming it. It reports false at the end of the expression.
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+
+	return p.tokens[p.pos], true
+}
+
+// keyword consumes the next token if it is one of the provided keywords.
+func (p *parser) keyword(keywords ...string) bool {
+	t, ok := p.peek()
+	if !ok || t.quoted {
+		return false
+	}
+
+	for _, k := range keywords {
+		if strings.EqualFold(t.text, k) {
+			p.pos++
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	for err == nil && p.keyword("or", "||") {
+		var right node
+		right, err = p.and()
+		left = or{left: left, right: right}
+	}
+
+	return left, err
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.unary()
+	for err == nil && p.keyword("and", "&&") {
+		var right node
+		right, err = p.unary()
+		left = and{left: left, right: right}
+	}
+
+	return left, err
+}
+
+func (p *parser) unary() (node, error) {
+	if p.keyword("not", "!") {
+		operand, err := p.unary()
+		return not{operand: operand}, err
+	}
+
+	if p.keyword("(") {
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.keyword(")") {
+			return nil, fmt.Errorf("%w: missing closing parenthesis", ErrSyntax)
+		}
+
+		return n, nil
+	}
+
+	return p.comparison()
+}
+
+func (p *parser) comparison() (node, error) {
+	field, ok := p.peek()
+	if !ok || field.quoted || field.text == ")" || field.text == "(" {
+		return nil, fmt.Errorf("%w: expected a field", ErrSyntax)
+	}
+
+	p.pos++
+	path := strings.Split(field.text, ".")
+	for _, key := range path {
+		if key == "" {
+			return nil, fmt.Errorf("%w: invalid field %q", ErrSyntax, field.text)
+		}
+	}
+
+	op, ok := p.peek()
+	switch {
+	case !ok || op.quoted:
+		return nil, fmt.Errorf("%w: expected an operator after %q", ErrSyntax, field.text)
+	case op.text == "=":
+		op.text = "=="
+	case op.text != "==" && op.text != "!=" && op.text != "<" && op.text != "<=" && op.text != ">" && op.text != ">=":
+		return nil, fmt.Errorf("%w: unknown operator %q", ErrSyntax, op.text)
+	}
+
+	p.pos++
+	v, ok := p.peek()
+	if !ok || (!v.quoted && (v.text == "(" || v.text == ")")) {
+		return nil, fmt.Errorf("%w: expected a value after %q", ErrSyntax, op.text)
+	}
+
+	p.pos++
+	return comparison{path: path, op: op.text, value: literal(v)}, nil
+}
+
+// literal returns the decoded JSON value of the token. Tokens which are not JSON values are taken as strings, including
+// words such as "inf" or "nan" which Go, but not JSON, parses as numbers.
+func literal(t token) interface{} {
+	if t.quoted {
+		return t.text
+	}
+
+	switch t.text {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+
+	var f float64
+	if err := json.Unmarshal([]byte(t.text), &f); err == nil {
+		return f
+	}
+
+	return t.text
+}
@@ -0,0 +1,124 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package filter
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		data string
+		want bool
+	}{
+		{"equal string", `region == "eu"`, `{"region":"eu"}`, true},
+		{"single equals", `region = eu`, `{"region":"eu"}`, true},
+		{"not equal string", `region != eu`, `{"region":"uk"}`, true},
+		{"nested field", `device.region == eu`, `{"device":{"region":"eu"}}`, true},
+		{"number", `count > 2`, `{"count":3}`, true},
+		{"number with exponent", `count == 1e3`, `{"count":1000}`, true},
+		{"negative number", `count < -1.5`, `{"count":-2}`, true},
+		{"boolean", `active == true`, `{"active":true}`, true},
+		{"null", `owner == null`, `{"owner":null}`, true},
+		{"strings lexically", `name < bob`, `{"name":"alice"}`, true},
+		{"severity by rank", `severity >= warn`, `{"severity":"error"}`, true},
+		{"severity below rank", `severity >= warn`, `{"severity":"info"}`, false},
+		{"severity ignores case", `severity > INFO`, `{"severity":"Warning"}`, true},
+
+		{"and binds tighter than or", `a == 1 or b == 1 and c == 1`, `{"a":1,"b":0,"c":0}`, true},
+		{"parentheses", `(a == 1 or b == 1) and c == 1`, `{"a":1,"b":0,"c":0}`, false},
+		{"not binds tighter than and", `not a == 1 and b == 1`, `{"a":0,"b":1}`, true},
+		{"not of parentheses", `not (a == 1 and b == 1)`, `{"a":1,"b":1}`, false},
+		{"symbolic operators", `!a == 1 && (b == 1 || c == 1)`, `{"a":0,"b":0,"c":1}`, true},
+		{"keywords ignore case", `a == 1 AND NOT b == 1`, `{"a":1,"b":0}`, true},
+
+		{"quoted keyword", `op == "and"`, `{"op":"and"}`, true},
+		{"quoted operator", `op == "<="`, `{"op":"<="}`, true},
+		{"quoted escape", `name == "a \"b\""`, `{"name":"a \"b\""}`, true},
+		{"quoted number is a string", `count == "1"`, `{"count":1}`, false},
+		{"quoted boolean is a string", `active == "true"`, `{"active":"true"}`, true},
+		{"bare word is a string", `region == eu`, `{"region":"eu"}`, true},
+
+		{"inf is a string", `v == inf`, `{"v":"inf"}`, true},
+		{"nan is a string", `v == nan`, `{"v":"nan"}`, true},
+		{"infinity is a string", `v == Infinity`, `{"v":"Infinity"}`, true},
+		{"inf is not a number", `v < inf`, `{"v":1}`, false},
+		{"hex is a string", `v == 0x10`, `{"v":16}`, false},
+
+		{"string against number", `count == "3"`, `{"count":3}`, false},
+		{"number against string", `count > 2`, `{"count":"3"}`, false},
+		{"mismatch matches not equal", `count != 2`, `{"count":"2"}`, true},
+		{"booleans are not ordered", `active > false`, `{"active":true}`, false},
+		{"missing field", `region == eu`, `{}`, false},
+		{"missing field matches not equal", `region != eu`, `{}`, true},
+		{"path through scalar", `device.region == eu`, `{"device":"eu"}`, false},
+		{"data not an object", `region == eu`, `"eu"`, false},
+		{"invalid data", `region == eu`, `{`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tt.expr, err)
+			}
+
+			if got := f.MatchJSON([]byte(tt.data)); got != tt.want {
+				t.Errorf("%q matched %s = %t, want %t", tt.expr, tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{"empty", ``},
+		{"unterminated string", `region == "eu`},
+		{"invalid escape", `region == "\q"`},
+		{"missing operator", `region`},
+		{"unknown operator", `region <> eu`},
+		{"missing value", `region ==`},
+		{"value is parenthesis", `region == (`},
+		{"quoted field", `"region" == eu`},
+		{"empty path segment", `device..region == eu`},
+		{"missing closing parenthesis", `(region == eu`},
+		{"unexpected closing parenthesis", `region == eu)`},
+		{"dangling and", `region == eu and`},
+		{"dangling not", `not`},
+		{"trailing token", `region == eu uk`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.expr); !errors.Is(err, ErrSyntax) {
+				t.Errorf("Parse(%q) error = %v, want %v", tt.expr, err, ErrSyntax)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package ack provides the handler invoked by Amazon API Gateway when a client acknowledges a message which was
+// published in acknowledgement mode.
+package ack
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/ack"
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw"
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw/ws"
+	"com.aws-samples/apigateway.websockets.golang/lib/handler"
+	"com.aws-samples/apigateway.websockets.golang/lib/logger"
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/tenant"
+	"com.aws-samples/apigateway.websockets.golang/lib/tracing"
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Dependencies holds the store of the pending deliveries which are acknowledged.
+type Dependencies struct {
+	Acks *ack.Store
+
+	// Tenants resolves the tenant of the acknowledging connection.
+	Tenants *tenant.Resolver
+
+	// Metrics creates the recorder for the metrics of each invocation. A nil Emitter discards all metrics.
+	Metrics *metrics.Emitter
+}
+
+// Handler handles WebSocket acknowledgements.
+type Handler struct {
+	acks    *ack.Store
+	tenants *tenant.Resolver
+	metrics *metrics.Emitter
+}
+
+// NewHandler creates a new Handler from the provided dependencies.
+func NewHandler(deps Dependencies) *Handler {
+	return &Handler{acks: deps.Acks, tenants: deps.Tenants, metrics: deps.Metrics}
+}
+
+// Handle receives a synchronous invocation from API Gateway when a client acknowledges a message. The delivery of the
+// message to the client's connection is removed so that it is not redelivered.
+func (h *Handler) Handle(ctx context.Context, req *events.APIGatewayWebsocketProxyRequest) (res apigw.Response, err error) {
+	ctx = logger.ForRequest(ctx, req)
+	log := logger.FromContext(ctx)
+
+	ctx, span := tracing.Tracer().Start(ctx, "websocket ack", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(tracing.RequestAttributes(req)...))
+
+	rec := h.metrics.Recorder()
+	rec.SetDimension(metrics.DimensionStage, req.RequestContext.Stage)
+
+	defer func() { handler.Finish(ctx, span, rec, err) }()
+
+	input, err := new(ws.AckEnvelop).Decode([]byte(req.Body))
+	if err == nil && input.ID == "" {
+		err = errors.New("acknowledgement without message id")
+	} else if err == nil {
+		err = ws.ValidateID(input.ID)
+	}
+
+	if err != nil {
+		log.Error("failed to parse client acknowledgement", zap.Error(err))
+		return apigw.BadRequestResponse(), err
+	}
+
+	tenantID, res, err := handler.ResolveTenant(ctx, h.tenants, req, rec)
+	if err != nil {
+		return res, err
+	}
+
+	d := ack.Delivery{Tenant: tenantID, ConnectionID: req.RequestContext.ConnectionID, MessageID: input.ID}
+	start := time.Now()
+	pending, err := h.acks.Ack(ctx, d)
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		log.Error("failed to acknowledge message", zap.String("messageId", input.ID), zap.Error(err))
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	log.Info("websocket message acknowledged", zap.String("messageId", input.ID), zap.Bool("pending", pending))
+
+	rec.Increment(metrics.AcksReceived, 1)
+	return apigw.OkResponse(), nil
+}
@@ -0,0 +1,251 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package connect provides the handler invoked by Amazon API Gateway when a new WebSocket connection is created.
+package connect
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw"
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw/ws"
+	"com.aws-samples/apigateway.websockets.golang/lib/handler"
+	"com.aws-samples/apigateway.websockets.golang/lib/logger"
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/policy"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"com.aws-samples/apigateway.websockets.golang/lib/session"
+	"com.aws-samples/apigateway.websockets.golang/lib/tenant"
+	"com.aws-samples/apigateway.websockets.golang/lib/tracing"
+	"com.aws-samples/apigateway.websockets.golang/lib/user"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	radix "github.com/mediocregopher/radix/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// addScript adds the connection to the connections of the tenant, unless the tenant already reached its connection
+// limit. A limit of zero means no limit. The script replies with 1 if the connection was added, and 0 otherwise.
+var addScript = radix.NewEvalScript(1, `
+local limit = tonumber(ARGV[2])
+if limit > 0 and redis.call("SCARD", KEYS[1]) >= limit then
+	return 0
+end
+redis.call("SADD", KEYS[1], ARGV[1])
+return 1
+`)
+
+// Dependencies holds the clients which admit new connections and record their state.
+type Dependencies struct {
+	Redis redis.Client
+
+	// Tenants resolves the tenant of the connection and its connection limit. When nil, a resolver with the default
+	// options is created from Redis.
+	Tenants *tenant.Resolver
+
+	// Users limits the connections of each authenticated user. When nil, a limiter with the default options, which
+	// does not limit connections, is created from Redis.
+	Users *user.Limiter
+
+	// Policy stores the claims of the connection against which the channel policy is evaluated. A nil Engine stores
+	// nothing.
+	Policy *policy.Engine
+
+	// Config is the base or parent AWS configuration used to create the Amazon API Gateway Management API client,
+	// which closes the connections evicted by Users.
+	Config aws.Config
+
+	// ManagementAPI is an optional, preconfigured Amazon API Gateway Management API client. When nil, the client is
+	// lazily created from Config upon the first eviction.
+	ManagementAPI *apigatewaymanagementapi.Client
+
+	// Metrics creates the recorder for the metrics of each invocation. A nil Emitter discards all metrics.
+	Metrics *metrics.Emitter
+}
+
+// Handler handles WebSocket connect requests.
+type Handler struct {
+	redis     redis.Client
+	tenants   *tenant.Resolver
+	users     *user.Limiter
+	closer    *session.Closer
+	policy    *policy.Engine
+	cfg       aws.Config
+	apiClient *apigatewaymanagementapi.Client
+	metrics   *metrics.Emitter
+}
+
+// NewHandler creates a new Handler from the provided dependencies.
+func NewHandler(deps Dependencies) *Handler {
+	tenants := deps.Tenants
+	if tenants == nil {
+		tenants = tenant.NewResolver(deps.Redis, tenant.DefaultOptions())
+	}
+
+	users := deps.Users
+	if users == nil {
+		users = user.NewLimiter(deps.Redis, user.DefaultOptions())
+	}
+
+	return &Handler{
+		redis:     deps.Redis,
+		tenants:   tenants,
+		users:     users,
+		closer:    session.NewCloser(deps.Redis, tenants, users),
+		policy:    deps.Policy,
+		cfg:       deps.Config,
+		apiClient: deps.ManagementAPI,
+		metrics:   deps.Metrics,
+	}
+}
+
+// Handle receives a synchronous invocation from API Gateway when a new WebSocket connection is created for the
+// application's API. The connection details are cached in the application's Redis cache which makes the connection
+// available to the other application components. Connections without a valid tenant, or of tenants which reached their
+// connection limit, are rejected. Connections of users who reached their connection limit are either rejected or
+// replace the oldest connections of the user, which are then closed.
+func (h *Handler) Handle(ctx context.Context, req *events.APIGatewayWebsocketProxyRequest) (res apigw.Response, err error) {
+	ctx = logger.ForRequest(ctx, req)
+	log := logger.FromContext(ctx)
+
+	ctx, span := tracing.Tracer().Start(ctx, "websocket connect", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(tracing.RequestAttributes(req)...))
+
+	rec := h.metrics.Recorder()
+	rec.SetDimension(metrics.DimensionStage, req.RequestContext.Stage)
+
+	defer func() { handler.Finish(ctx, span, rec, err) }()
+
+	log.Info("websocket connect")
+
+	start := time.Now()
+	id, err := h.tenants.Connect(ctx, req)
+	rec.Since(metrics.RedisLatency, start)
+	switch err {
+	case nil:
+	case tenant.ErrMissing, tenant.ErrInvalidID:
+		log.Error("failed to resolve tenant", zap.Error(err))
+		return apigw.ForbiddenResponse(), err
+	default:
+		log.Error("failed to cache connection tenant", zap.Error(err))
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	ctx = logger.With(ctx, zap.String("tenant", id))
+	log = logger.FromContext(ctx)
+	span.SetAttributes(attribute.String("tenant.id", id))
+
+	ks := redis.Tenant(id)
+	start = time.Now()
+	err = h.policy.Remember(ctx, ks, req)
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		log.Error("failed to cache connection claims", zap.Error(err))
+		h.reject(ctx, ks, req, rec)
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	var added int
+	limit := h.tenants.MaxConnections(id)
+	start = time.Now()
+	err = redis.Do(ctx, h.redis, "EVALSHA", addScript.Cmd(&added, ks.ConnectionsKey(),
+		req.RequestContext.ConnectionID, strconv.Itoa(limit)))
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		log.Error("failed to cache connection details", zap.Error(err))
+		h.reject(ctx, ks, req, rec)
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	if added == 0 {
+		log.Info("reject connection as tenant reached its connection limit", zap.Int("limit", limit))
+		h.reject(ctx, ks, req, rec)
+		return apigw.TooManyRequestsResponse(), nil
+	}
+
+	start = time.Now()
+	admission, err := h.users.Connect(ctx, ks, req)
+	rec.Since(metrics.RedisLatency, start)
+	switch {
+	case err == user.ErrInvalidID:
+		log.Error("failed to resolve user", zap.Error(err))
+		h.reject(ctx, ks, req, rec)
+		return apigw.ForbiddenResponse(), err
+	case err != nil:
+		log.Error("failed to cache user connection", zap.Error(err))
+		h.reject(ctx, ks, req, rec)
+		return apigw.InternalServerErrorResponse(), err
+	case !admission.Accepted:
+		log.Info("reject connection as user reached its connection limit")
+		h.reject(ctx, ks, req, rec)
+		return apigw.TooManyRequestsResponse(), nil
+	}
+
+	for _, id := range admission.Evicted {
+		h.evict(ctx, req, id, rec)
+	}
+
+	log.Info("websocket connection cached")
+
+	rec.Increment(metrics.ConnectionsAdded, 1)
+	return apigw.OkResponse(), nil
+}
+
+// reject removes what was cached for a connection which is rejected, or which failed to connect once its tenant was
+// recorded: its tenant, claims, user, and its membership in the connections of the tenant and of its user, as far as
+// they were cached.
+func (h *Handler) reject(ctx context.Context, ks redis.Keyspace, req *events.APIGatewayWebsocketProxyRequest,
+	rec *metrics.Recorder) {
+	start := time.Now()
+	_, err := h.closer.Cleanup(ctx, ks, req.RequestContext.ConnectionID)
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to remove connection details", zap.Error(err))
+	}
+
+	rec.Increment(metrics.ConnectionsRejected, 1)
+}
+
+// evict closes a connection which was replaced by a newer connection of the same user.
+func (h *Handler) evict(ctx context.Context, req *events.APIGatewayWebsocketProxyRequest, id string,
+	rec *metrics.Recorder) {
+	log := logger.FromContext(ctx).With(zap.String("evictedConnectionId", id))
+
+	// Lazily initialize the API Gateway Management client, as the endpoint of the API is only known from the request.
+	if h.apiClient == nil {
+		h.apiClient = apigw.NewAPIGatewayManagementClient(&h.cfg, req.RequestContext.DomainName, req.RequestContext.Stage)
+	}
+
+	err := h.closer.Close(ctx, h.apiClient, id, ws.CloseReplaced, "replaced by a newer connection of the same user")
+	if err != nil {
+		log.Error("failed to close evicted connection", zap.Error(err))
+		return
+	}
+
+	log.Info("evicted oldest connection of user")
+	rec.Increment(metrics.ConnectionsEvicted, 1)
+}
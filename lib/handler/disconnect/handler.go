@@ -0,0 +1,135 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package disconnect provides the handler invoked by Amazon API Gateway when a WebSocket connection is closed.
+package disconnect
+
+import (
+	"context"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw"
+	"com.aws-samples/apigateway.websockets.golang/lib/handler"
+	"com.aws-samples/apigateway.websockets.golang/lib/logger"
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"com.aws-samples/apigateway.websockets.golang/lib/session"
+	"com.aws-samples/apigateway.websockets.golang/lib/tenant"
+	"com.aws-samples/apigateway.websockets.golang/lib/tracing"
+	"com.aws-samples/apigateway.websockets.golang/lib/user"
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Dependencies holds the clients which remove the state of closed connections.
+type Dependencies struct {
+	Redis redis.Client
+
+	// Tenants resolves the tenant of the connection. When nil, a resolver with the default options is created from
+	// Redis.
+	Tenants *tenant.Resolver
+
+	// Users tracks the connections of each authenticated user. When nil, a limiter with the default options is created
+	// from Redis.
+	Users *user.Limiter
+
+	// Metrics creates the recorder for the metrics of each invocation. A nil Emitter discards all metrics.
+	Metrics *metrics.Emitter
+}
+
+// Handler handles WebSocket disconnect requests.
+type Handler struct {
+	tenants *tenant.Resolver
+	closer  *session.Closer
+	metrics *metrics.Emitter
+}
+
+// NewHandler creates a new Handler from the provided dependencies.
+func NewHandler(deps Dependencies) *Handler {
+	tenants := deps.Tenants
+	if tenants == nil {
+		tenants = tenant.NewResolver(deps.Redis, tenant.DefaultOptions())
+	}
+
+	users := deps.Users
+	if users == nil {
+		users = user.NewLimiter(deps.Redis, user.DefaultOptions())
+	}
+
+	return &Handler{
+		tenants: tenants,
+		closer:  session.NewCloser(deps.Redis, tenants, users),
+		metrics: deps.Metrics,
+	}
+}
+
+// Handle receives a synchronous invocation from API Gateway when a new connection has been disconnected from the
+// application's API. The connection details are removed in the application's Redis cache which cleans up the connection
+// details. This handler is not guaranteed to be called when the WebSocket connection is closed.
+func (h *Handler) Handle(ctx context.Context, req *events.APIGatewayWebsocketProxyRequest) (res apigw.Response, err error) {
+	ctx = logger.ForRequest(ctx, req)
+	log := logger.FromContext(ctx)
+
+	ctx, span := tracing.Tracer().Start(ctx, "websocket disconnect", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(tracing.RequestAttributes(req)...))
+
+	rec := h.metrics.Recorder()
+	rec.SetDimension(metrics.DimensionStage, req.RequestContext.Stage)
+
+	defer func() { handler.Finish(ctx, span, rec, err) }()
+
+	log.Info("websocket disconnect")
+
+	// A connection whose tenant is unknown was either rejected on connect or already cleaned up.
+	start := time.Now()
+	id, err := h.tenants.Resolve(ctx, req)
+	rec.Since(metrics.RedisLatency, start)
+	if err == tenant.ErrUnknownConnection {
+		log.Info("skip connection of unknown tenant")
+		return apigw.OkResponse(), nil
+	}
+
+	if err != nil {
+		log.Error("failed to resolve tenant", zap.Error(err))
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	log = log.With(zap.String("tenant", id))
+
+	start = time.Now()
+	removed, err := h.closer.Cleanup(ctx, redis.Tenant(id), req.RequestContext.ConnectionID)
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		log.Error("failed to delete connection details from cache", zap.Error(err))
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	log.Info("websocket connection deleted from cache", zap.Bool("removed", removed))
+
+	// A connection which was already removed, for example as a publish found it to be gone, is not counted again.
+	if removed {
+		rec.Increment(metrics.ConnectionsRemoved, 1)
+	}
+
+	return apigw.OkResponse(), nil
+}
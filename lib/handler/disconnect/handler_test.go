@@ -0,0 +1,206 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package disconnect
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"github.com/aws/aws-lambda-go/events"
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// store is an in-memory Redis client implementing the commands used by the Handler.
+type store struct {
+	mu      sync.Mutex
+	strings map[string]string
+	sets    map[string]map[string]bool
+}
+
+func newStore() *store {
+	return &store{strings: make(map[string]string), sets: make(map[string]map[string]bool)}
+}
+
+func (s *store) Do(a radix.Action) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return a.Run(radix.Stub("", "", s.exec))
+}
+
+func (s *store) DoRead(a radix.Action) error { return s.Do(a) }
+
+func (s *store) Close() error { return nil }
+
+func (s *store) exec(args []string) interface{} {
+	switch args[0] {
+	case "GET":
+		if v, ok := s.strings[args[1]]; ok {
+			return v
+		}
+
+		return nil
+	case "DEL":
+		removed := 0
+		for _, key := range args[1:] {
+			if _, ok := s.strings[key]; ok {
+				removed++
+			}
+
+			if _, ok := s.sets[key]; ok {
+				removed++
+			}
+
+			delete(s.strings, key)
+			delete(s.sets, key)
+		}
+
+		return removed
+	case "SADD":
+		if s.sets[args[1]] == nil {
+			s.sets[args[1]] = make(map[string]bool)
+		}
+
+		added := 0
+		for _, m := range args[2:] {
+			if !s.sets[args[1]][m] {
+				s.sets[args[1]][m] = true
+				added++
+			}
+		}
+
+		return added
+	case "SREM":
+		removed := 0
+		for _, m := range args[2:] {
+			if s.sets[args[1]][m] {
+				delete(s.sets[args[1]], m)
+				removed++
+			}
+		}
+
+		return removed
+	case "SMEMBERS":
+		members := []string{}
+		for m := range s.sets[args[1]] {
+			members = append(members, m)
+		}
+
+		return members
+	}
+
+	return errors.New("ERR unknown command " + args[0])
+}
+
+func request(id string) *events.APIGatewayWebsocketProxyRequest {
+	return &events.APIGatewayWebsocketProxyRequest{
+		RequestContext: events.APIGatewayWebsocketProxyRequestContext{
+			ConnectionID: id,
+			RouteKey:     "$disconnect",
+			Stage:        "dev",
+		},
+	}
+}
+
+func TestHandle(t *testing.T) {
+	client := newStore()
+	client.strings[redis.ConnectionTenantKey("conn1")] = "acme"
+	client.sets[redis.Tenant("acme").ConnectionsKey()] = map[string]bool{"conn1": true, "conn2": true}
+
+	sink := &metrics.MemorySink{}
+	h := NewHandler(Dependencies{Redis: client, Metrics: metrics.NewEmitter("Test", sink)})
+	res, err := h.Handle(context.Background(), request("conn1"))
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Handle returned %d, %v, want %d", res.StatusCode, err, http.StatusOK)
+	}
+
+	if _, ok := client.strings[redis.ConnectionTenantKey("conn1")]; ok {
+		t.Error("tenant of the connection was not deleted")
+	}
+
+	connections := client.sets[redis.Tenant("acme").ConnectionsKey()]
+	if want := map[string]bool{"conn2": true}; !reflect.DeepEqual(connections, want) {
+		t.Errorf("got connections %v, want %v", connections, want)
+	}
+
+	docs := sink.Documents()
+	if len(docs) != 1 {
+		t.Fatalf("got %d documents, want 1", len(docs))
+	}
+
+	if got := docs[0].Dimensions[metrics.DimensionStage]; got != "dev" {
+		t.Errorf("got dimension %s %q, want %q", metrics.DimensionStage, got, "dev")
+	}
+
+	if got := sink.Sum(metrics.ConnectionsRemoved); got != 1 {
+		t.Errorf("got %s %v, want 1", metrics.ConnectionsRemoved, got)
+	}
+
+	if got := len(sink.Values(metrics.RedisLatency)); got != 2 {
+		t.Errorf("got %d values of %s, want 2", got, metrics.RedisLatency)
+	}
+}
+
+func TestHandleUnknownConnection(t *testing.T) {
+	sink := &metrics.MemorySink{}
+	h := NewHandler(Dependencies{Redis: newStore(), Metrics: metrics.NewEmitter("Test", sink)})
+	res, err := h.Handle(context.Background(), request("conn1"))
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Handle returned %d, %v, want %d", res.StatusCode, err, http.StatusOK)
+	}
+
+	if _, ok := sink.Documents()[0].Value(metrics.ConnectionsRemoved); ok {
+		t.Errorf("%s emitted for a connection of an unknown tenant", metrics.ConnectionsRemoved)
+	}
+
+	if got := len(sink.Values(metrics.RedisLatency)); got != 1 {
+		t.Errorf("got %d values of %s, want 1", got, metrics.RedisLatency)
+	}
+}
+
+func TestHandleRemovedConnection(t *testing.T) {
+	client := newStore()
+	client.strings[redis.ConnectionTenantKey("conn1")] = "acme"
+	client.sets[redis.Tenant("acme").ConnectionsKey()] = map[string]bool{"conn2": true}
+
+	sink := &metrics.MemorySink{}
+	h := NewHandler(Dependencies{Redis: client, Metrics: metrics.NewEmitter("Test", sink)})
+	res, err := h.Handle(context.Background(), request("conn1"))
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Handle returned %d, %v, want %d", res.StatusCode, err, http.StatusOK)
+	}
+
+	if _, ok := client.strings[redis.ConnectionTenantKey("conn1")]; ok {
+		t.Error("tenant of the connection was not deleted")
+	}
+
+	if _, ok := sink.Documents()[0].Value(metrics.ConnectionsRemoved); ok {
+		t.Errorf("%s emitted for a connection which was already removed", metrics.ConnectionsRemoved)
+	}
+}
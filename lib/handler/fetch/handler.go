@@ -0,0 +1,300 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package fetch provides the handler invoked by Amazon API Gateway when a client requests the messages of a channel
+// it missed. The messages are sent again to the requesting connection only.
+package fetch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw"
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw/ws"
+	"com.aws-samples/apigateway.websockets.golang/lib/channel"
+	"com.aws-samples/apigateway.websockets.golang/lib/filter"
+	"com.aws-samples/apigateway.websockets.golang/lib/handler"
+	"com.aws-samples/apigateway.websockets.golang/lib/logger"
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/policy"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"com.aws-samples/apigateway.websockets.golang/lib/tenant"
+	"com.aws-samples/apigateway.websockets.golang/lib/tracing"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// MaxRange is the maximum number of messages which can be requested at once.
+const MaxRange = 100
+
+// Dependencies holds the channel histories and the clients the replayed messages are delivered with.
+type Dependencies struct {
+	History *channel.History
+
+	// Subscriptions holds the subscription of the requesting connection, which must be subscribed to the channel.
+	// Only the messages matching the filter of its subscription are sent.
+	Subscriptions *channel.Subscriptions
+
+	// Tenants resolves the tenant of the requesting connection, whose channels are the only ones it can fetch from.
+	Tenants *tenant.Resolver
+
+	// Policy decides whether the connection may read the channel. A nil Engine allows every channel.
+	Policy *policy.Engine
+
+	// Config is the base or parent AWS configuration used to create the Amazon API Gateway Management API client.
+	Config aws.Config
+
+	// ManagementAPI is an optional, preconfigured Amazon API Gateway Management API client. When nil, the client is
+	// lazily created from Config upon the first invocation.
+	ManagementAPI *apigatewaymanagementapi.Client
+
+	// Metrics creates the recorder for the metrics of each invocation. A nil Emitter discards all metrics.
+	Metrics *metrics.Emitter
+}
+
+// Handler handles WebSocket fetch requests.
+type Handler struct {
+	history   *channel.History
+	subs      *channel.Subscriptions
+	tenants   *tenant.Resolver
+	policy    *policy.Engine
+	cfg       aws.Config
+	apiClient *apigatewaymanagementapi.Client
+	metrics   *metrics.Emitter
+}
+
+// NewHandler creates a new Handler from the provided dependencies.
+func NewHandler(deps Dependencies) *Handler {
+	return &Handler{
+		history:   deps.History,
+		subs:      deps.Subscriptions,
+		tenants:   deps.Tenants,
+		policy:    deps.Policy,
+		cfg:       deps.Config,
+		apiClient: deps.ManagementAPI,
+		metrics:   deps.Metrics,
+	}
+}
+
+// Handle receives a synchronous invocation from API Gateway when a client requests a range of messages of a channel.
+// The messages still held by the channel's history which match the filter of the client's subscription are sent to
+// the client in order, followed by a FetchedEnvelop listing the sequence numbers which are no longer available and
+// those which were filtered out. Clients can only fetch the messages of channels they are subscribed to.
+func (h *Handler) Handle(ctx context.Context, req *events.APIGatewayWebsocketProxyRequest) (res apigw.Response, err error) {
+	ctx = logger.ForRequest(ctx, req)
+	log := logger.FromContext(ctx)
+
+	ctx, span := tracing.Tracer().Start(ctx, "websocket fetch", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(tracing.RequestAttributes(req)...))
+
+	rec := h.metrics.Recorder()
+	rec.SetDimension(metrics.DimensionStage, req.RequestContext.Stage)
+
+	defer func() { handler.Finish(ctx, span, rec, err) }()
+
+	if h.apiClient == nil {
+		h.apiClient = apigw.NewAPIGatewayManagementClient(&h.cfg, req.RequestContext.DomainName, req.RequestContext.Stage)
+	}
+
+	input, err := new(ws.FetchEnvelop).Decode([]byte(req.Body))
+	if err == nil {
+		err = validate(input)
+	}
+
+	if err != nil {
+		log.Error("failed to parse client fetch request", zap.Error(err))
+		return apigw.BadRequestResponse(), err
+	}
+
+	tenantID, res, err := handler.ResolveTenant(ctx, h.tenants, req, rec)
+	if err != nil {
+		return res, err
+	}
+
+	log = log.With(
+		zap.String("tenant", tenantID),
+		zap.String("channel", input.Channel),
+		zap.Int64("from", input.From),
+		zap.Int64("to", input.To),
+	)
+
+	// Fetching the history of a channel reveals its messages just like subscribing to it does.
+	ks := redis.Tenant(tenantID)
+	start := time.Now()
+	allowed, err := h.policy.Authorize(ctx, ks, req.RequestContext.ConnectionID, policy.Subscribe, input.Channel)
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		log.Error("failed to read connection claims from cache", zap.Error(err))
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	if !allowed {
+		log.Info("deny fetch by channel policy")
+		rec.Increment(metrics.ChannelAccessDenied, 1)
+		return apigw.ForbiddenResponse(), nil
+	}
+
+	id := req.RequestContext.ConnectionID
+	start = time.Now()
+	sub, subscribed, err := h.subs.Subscription(ctx, ks, input.Channel, id)
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		log.Error("failed to read connection subscriptions from cache", zap.Error(err))
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	if !subscribed {
+		log.Info("deny fetch of channel not subscribed to")
+		return apigw.ForbiddenResponse(), nil
+	}
+
+	// Subscriptions with invalid filters are rejected, so such a filter matches nothing.
+	var f *filter.Filter
+	if sub.Filter != "" {
+		if f, err = filter.Parse(sub.Filter); err != nil {
+			log.Error("failed to parse subscription filter", zap.String("filter", sub.Filter), zap.Error(err))
+		}
+	}
+
+	start = time.Now()
+	entries, err := h.history.Range(ctx, ks, input.Channel, input.From, input.To)
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		log.Error("failed to read channel history from cache", zap.Error(err))
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	// Send the messages one at a time, in order, so that the client receives them in the same way as they were
+	// published.
+	reply := &ws.FetchedEnvelop{Message: "fetched", Channel: input.Channel, From: input.From, To: input.To,
+		Missing: []int64{}}
+	next, fetched, expired := input.From, 0, 0
+	now := time.Now()
+	for _, e := range entries {
+		for ; next < e.Seq; next++ {
+			reply.Missing = append(reply.Missing, next)
+		}
+
+		next = e.Seq + 1
+
+		// Expired messages are no longer delivered by the fan-out, and are reported as missing instead.
+		if isExpired(e.Payload, now) {
+			reply.Missing = append(reply.Missing, e.Seq)
+			expired++
+			continue
+		}
+
+		// Messages which the subscription does not receive are skipped, as the fan-out skips them.
+		if sub.Filter != "" && (f == nil || !f.Match(data(e.Payload))) {
+			reply.Filtered = append(reply.Filtered, e.Seq)
+			continue
+		}
+
+		timing, err := apigw.PostToConnection(ctx, h.apiClient, id, e.Payload)
+		timing.Record(rec)
+		if err != nil {
+			log.Error("failed to send fetched message", zap.Int64("seq", e.Seq), zap.Error(err))
+			return apigw.InternalServerErrorResponse(), err
+		}
+
+		fetched++
+	}
+
+	for ; next <= input.To; next++ {
+		reply.Missing = append(reply.Missing, next)
+	}
+
+	payload, err := reply.Encode()
+	if err != nil {
+		log.Error("failed to encode output", zap.Error(err))
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	timing, err := apigw.PostToConnection(ctx, h.apiClient, id, payload)
+	timing.Record(rec)
+	if err != nil {
+		log.Error("failed to send fetch reply", zap.Error(err))
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	log.Info("websocket messages fetched", zap.Int("messages", fetched), zap.Int("expired", expired),
+		zap.Int("filtered", len(reply.Filtered)), zap.Int("missing", len(reply.Missing)))
+
+	rec.Increment(metrics.MessagesFetched, float64(fetched))
+	rec.Increment(metrics.MessagesMissing, float64(len(reply.Missing)))
+	rec.Increment(metrics.MessagesExpired, float64(expired))
+	rec.Increment(metrics.DeliveriesFiltered, float64(len(reply.Filtered)))
+	return apigw.OkResponse(), nil
+}
+
+// isExpired reports whether the message encoded in the payload expired at the provided time.
+func isExpired(payload []byte, now time.Time) bool {
+	var output struct {
+		ExpiresAt int64 `json:"expiresAt"`
+	}
+
+	if err := json.Unmarshal(payload, &output); err != nil {
+		return false
+	}
+
+	return output.ExpiresAt > 0 && now.UnixMilli() >= output.ExpiresAt
+}
+
+// data decodes the data of the message encoded in the payload, which filters are matched against.
+func data(payload []byte) interface{} {
+	var output struct {
+		Data json.RawMessage `json:"data"`
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(payload, &output); err == nil {
+		_ = json.Unmarshal(output.Data, &v)
+	}
+
+	return v
+}
+
+// validate checks the channel and range of the fetch request.
+func validate(input *ws.FetchEnvelop) error {
+	if err := channel.Validate(input.Channel); err != nil {
+		return err
+	}
+
+	if channel.IsPattern(input.Channel) {
+		return errors.New("invalid channel: the history of patterns can not be fetched")
+	}
+
+	if input.From < 1 || input.To < input.From {
+		return errors.New("invalid range: from must be positive and not greater than to")
+	}
+
+	if input.To-input.From >= MaxRange {
+		return errors.New("invalid range: too many messages requested")
+	}
+
+	return nil
+}
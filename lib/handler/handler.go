@@ -0,0 +1,76 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package handler holds what the handlers of the application share. Each handler package provides a Handler, created by
+// NewHandler from its Dependencies. The dependencies are created by the caller, typically once per AWS Lambda execution
+// context, and reused across invocations.
+package handler
+
+import (
+	"context"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw"
+	"com.aws-samples/apigateway.websockets.golang/lib/logger"
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/tenant"
+	"com.aws-samples/apigateway.websockets.golang/lib/tracing"
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Finish ends the span of an invocation with its error, then exports the spans, emits the metrics and flushes the logs
+// of the invocation, before the execution context is frozen. It is deferred by every handler.
+func Finish(ctx context.Context, span trace.Span, rec *metrics.Recorder, err error) {
+	log := logger.FromContext(ctx)
+	tracing.End(span, err)
+	if err := tracing.Flush(ctx); err != nil {
+		log.Error("failed to export spans", zap.Error(err))
+	}
+
+	if err := rec.Flush(); err != nil {
+		log.Error("failed to emit metrics", zap.Error(err))
+	}
+
+	_ = logger.Instance.Sync()
+}
+
+// ResolveTenant resolves the tenant of a request of an established connection. When the tenant can not be resolved, the
+// failure is logged and the response to reply with is returned along with the error: 403 Forbidden for connections of
+// an unknown or invalid tenant, and 500 Internal Server Error otherwise.
+func ResolveTenant(ctx context.Context, tenants *tenant.Resolver, req *events.APIGatewayWebsocketProxyRequest,
+	rec *metrics.Recorder) (string, apigw.Response, error) {
+	start := time.Now()
+	id, err := tenants.Resolve(ctx, req)
+	rec.Since(metrics.RedisLatency, start)
+	switch err {
+	case nil:
+		return id, apigw.OkResponse(), nil
+	case tenant.ErrUnknownConnection, tenant.ErrInvalidID:
+		logger.FromContext(ctx).Error("failed to resolve tenant", zap.Error(err))
+		return "", apigw.ForbiddenResponse(), err
+	default:
+		logger.FromContext(ctx).Error("failed to read connection tenant from cache", zap.Error(err))
+		return "", apigw.InternalServerErrorResponse(), err
+	}
+}
@@ -0,0 +1,120 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package kick provides the handler invoked by operators to close WebSocket connections on behalf of the server.
+package kick
+
+import (
+	"context"
+	"errors"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw/ws"
+	"com.aws-samples/apigateway.websockets.golang/lib/handler"
+	"com.aws-samples/apigateway.websockets.golang/lib/logger"
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/session"
+	"com.aws-samples/apigateway.websockets.golang/lib/tracing"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// ErrMissingConnectionIDs is returned for requests which do not name any connection.
+var ErrMissingConnectionIDs = errors.New("missing connection ids")
+
+// Request is the event the function is invoked with, for example:
+//
+//	{ "connectionIds": ["L0SM9cOFvHcCIhw="], "code": "kicked", "reason": "terms of service violation" }
+//
+// Code defaults to ws.CloseKicked.
+type Request struct {
+	ConnectionIDs []string `json:"connectionIds"`
+	Code          string   `json:"code,omitempty"`
+	Reason        string   `json:"reason,omitempty"`
+}
+
+// Response is the result of the invocation. Failed holds the IDs of the connections which could not be closed, which
+// may be retried.
+type Response struct {
+	Closed int      `json:"closed"`
+	Failed []string `json:"failed,omitempty"`
+}
+
+// Dependencies holds the Closer of the connections and the client of the API they belong to.
+type Dependencies struct {
+	Closer *session.Closer
+
+	// ManagementAPI is the Amazon API Gateway Management API client configured with the endpoint of the application's
+	// API. Unlike the handlers invoked by Amazon API Gateway, this handler can not derive the endpoint from the request.
+	ManagementAPI *apigatewaymanagementapi.Client
+
+	// Metrics creates the recorder for the metrics of each invocation. A nil Emitter discards all metrics.
+	Metrics *metrics.Emitter
+}
+
+// Handler closes connections on request.
+type Handler struct {
+	closer    *session.Closer
+	apiClient *apigatewaymanagementapi.Client
+	metrics   *metrics.Emitter
+}
+
+// NewHandler creates a new Handler from the provided dependencies.
+func NewHandler(deps Dependencies) *Handler {
+	return &Handler{closer: deps.Closer, apiClient: deps.ManagementAPI, metrics: deps.Metrics}
+}
+
+// Handle receives a direct invocation and closes the requested connections. Each client is sent a "server.closing"
+// notice with the code and reason of the request before its connection is closed, and the state of the connection is
+// then removed from the cache the same way as when the client disconnects.
+func (h *Handler) Handle(ctx context.Context, req Request) (res Response, err error) {
+	if req.Code == "" {
+		req.Code = ws.CloseKicked
+	}
+
+	log := logger.FromContext(ctx).With(zap.String("code", req.Code))
+
+	ctx, span := tracing.Tracer().Start(ctx, "kick", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.Int("websocket.connections", len(req.ConnectionIDs))))
+	rec := h.metrics.Recorder()
+
+	defer func() { handler.Finish(ctx, span, rec, err) }()
+
+	if len(req.ConnectionIDs) == 0 {
+		return res, ErrMissingConnectionIDs
+	}
+
+	for _, id := range req.ConnectionIDs {
+		if err := h.closer.Close(ctx, h.apiClient, id, req.Code, req.Reason); err != nil {
+			log.Error("failed to close connection", zap.String("connectionId", id), zap.Error(err))
+			res.Failed = append(res.Failed, id)
+			continue
+		}
+
+		log.Info("websocket connection closed", zap.String("connectionId", id), zap.String("reason", req.Reason))
+		res.Closed++
+	}
+
+	rec.Increment(metrics.ConnectionsClosed, float64(res.Closed))
+	return res, nil
+}
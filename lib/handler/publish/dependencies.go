@@ -0,0 +1,119 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package publish
+
+import (
+	"os"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/ack"
+	"com.aws-samples/apigateway.websockets.golang/lib/channel"
+	"com.aws-samples/apigateway.websockets.golang/lib/fanout"
+	"com.aws-samples/apigateway.websockets.golang/lib/handoff"
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/policy"
+	"com.aws-samples/apigateway.websockets.golang/lib/ratelimit"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"com.aws-samples/apigateway.websockets.golang/lib/schedule"
+	"com.aws-samples/apigateway.websockets.golang/lib/tenant"
+	"com.aws-samples/apigateway.websockets.golang/lib/user"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// DependenciesFromEnv creates the Dependencies shared by the publish, resume and dispatch functions from the provided
+// clients, configured by the environment variables read by the OptionsFromEnv function of each package. The metrics
+// are written to standard output. The Handoff queue is only created when HANDOFF_QUEUE_URL is set.
+func DependenciesFromEnv(client redis.Client, cfg aws.Config) (Dependencies, error) {
+	options, err := OptionsFromEnv()
+	if err != nil {
+		return Dependencies{}, err
+	}
+
+	ackOptions, err := ack.OptionsFromEnv()
+	if err != nil {
+		return Dependencies{}, err
+	}
+
+	channelOptions, err := channel.OptionsFromEnv()
+	if err != nil {
+		return Dependencies{}, err
+	}
+
+	fanoutOptions, err := fanout.OptionsFromEnv()
+	if err != nil {
+		return Dependencies{}, err
+	}
+
+	rateOptions, err := ratelimit.OptionsFromEnv()
+	if err != nil {
+		return Dependencies{}, err
+	}
+
+	tenantOptions, err := tenant.OptionsFromEnv()
+	if err != nil {
+		return Dependencies{}, err
+	}
+
+	policyOptions, err := policy.OptionsFromEnv()
+	if err != nil {
+		return Dependencies{}, err
+	}
+
+	userOptions, err := user.OptionsFromEnv()
+	if err != nil {
+		return Dependencies{}, err
+	}
+
+	scheduleOptions, err := schedule.OptionsFromEnv()
+	if err != nil {
+		return Dependencies{}, err
+	}
+
+	// The requests to the management API are paced by a token bucket shared by all instances. A nil Limiter must not be
+	// stored in the interface, which would then not be nil.
+	if limiter := ratelimit.NewLimiter(client, rateOptions); limiter != nil {
+		fanoutOptions.Limiter = limiter
+	}
+
+	// Recipients which can not be attempted before the deadline of an invocation are handed off through the queue to the
+	// ResumeFunction.
+	var queue *handoff.Queue
+	if url := os.Getenv(handoff.EnvQueueURL); url != "" {
+		queue = handoff.NewQueue(sqs.New(cfg), url)
+	}
+
+	return Dependencies{
+		Redis:    client,
+		Config:   cfg,
+		Metrics:  metrics.NewEmitter(metrics.NamespaceFromEnv(), metrics.NewWriterSink(os.Stdout)),
+		Acks:     ack.NewStore(client, ackOptions),
+		History:  channel.NewHistory(client, channelOptions),
+		Tenants:  tenant.NewResolver(client, tenantOptions),
+		Policy:   policy.NewEngine(client, policyOptions),
+		Users:    user.NewLimiter(client, userOptions),
+		FanOut:   fanout.New(fanoutOptions),
+		Handoff:  queue,
+		Schedule: schedule.NewStore(client, scheduleOptions),
+		Options:  options,
+	}, nil
+}
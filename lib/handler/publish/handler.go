@@ -0,0 +1,947 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package publish provides the handler invoked by Amazon API Gateway when data is sent from a client over a WebSocket
+// connection. The data is published to all connected clients.
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/ack"
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw"
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw/ws"
+	"com.aws-samples/apigateway.websockets.golang/lib/channel"
+	"com.aws-samples/apigateway.websockets.golang/lib/fanout"
+	"com.aws-samples/apigateway.websockets.golang/lib/filter"
+	"com.aws-samples/apigateway.websockets.golang/lib/handler"
+	"com.aws-samples/apigateway.websockets.golang/lib/handoff"
+	"com.aws-samples/apigateway.websockets.golang/lib/logger"
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/policy"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"com.aws-samples/apigateway.websockets.golang/lib/schedule"
+	"com.aws-samples/apigateway.websockets.golang/lib/session"
+	"com.aws-samples/apigateway.websockets.golang/lib/tenant"
+	"com.aws-samples/apigateway.websockets.golang/lib/tracing"
+	"com.aws-samples/apigateway.websockets.golang/lib/user"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	radix "github.com/mediocregopher/radix/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// maxRetries is the number of times a delivery which failed transiently is retried, with a backoff starting at
+// retryBackoff which doubles with each retry.
+const (
+	maxRetries   = 2
+	retryBackoff = 100 * time.Millisecond
+)
+
+// RouteCancel is the route of the requests to cancel a scheduled message, which are handled by the Handler as well.
+const RouteCancel = "cancel"
+
+// maxTargets is the maximum number of IDs in the exclude and the only list of a message.
+const maxTargets = 100
+
+var (
+	// errNoHandoff is returned when recipients remain after the deadline and no handoff queue is configured.
+	errNoHandoff = errors.New("recipients remaining and no handoff queue configured")
+
+	// errTooManyTargets is returned when the exclude or the only list of a message holds more than maxTargets IDs.
+	errTooManyTargets = errors.New("too many targets")
+
+	// errInvalidExpiry is returned when the TTL or the expiry of a message is negative.
+	errInvalidExpiry = errors.New("invalid expiry")
+)
+
+// Dependencies holds the stores of the published messages and the clients they are delivered with. See
+// DependenciesFromEnv for the dependencies of the AWS Lambda functions.
+type Dependencies struct {
+	Redis redis.Client
+
+	// Config is the base or parent AWS configuration used to create the Amazon API Gateway Management API client.
+	Config aws.Config
+
+	// ManagementAPI is an optional, preconfigured Amazon API Gateway Management API client. When nil, the client is
+	// lazily created from Config upon the first invocation.
+	ManagementAPI *apigatewaymanagementapi.Client
+
+	// Metrics creates the recorder for the metrics of each invocation. A nil Emitter discards all metrics.
+	Metrics *metrics.Emitter
+
+	// Acks tracks the deliveries of messages published in acknowledgement mode. When nil, a store with the default
+	// options is created from Redis.
+	Acks *ack.Store
+
+	// History assigns the sequence numbers of the published messages and keeps them for clients which missed
+	// messages. When nil, a history with the default options is created from Redis.
+	History *channel.History
+
+	// Tenants resolves the tenant of the publishing connection. When nil, a resolver with the default options is
+	// created from Redis.
+	Tenants *tenant.Resolver
+
+	// Subscriptions holds the subscribers of the channels other than the default channel. When nil, it is created from
+	// Redis.
+	Subscriptions *channel.Subscriptions
+
+	// Policy decides whether the connection may publish to the channel. A nil Engine allows every channel.
+	Policy *policy.Engine
+
+	// Users identifies the publishing user, and resolves the user IDs of the exclude and only lists of a message to the
+	// connections of the users. When nil, a limiter with the default options is created from Redis.
+	Users *user.Limiter
+
+	// FanOut sends each message to its recipients. When nil, an engine with the default options is created.
+	FanOut *fanout.Engine
+
+	// Handoff receives the recipients which could not be attempted before the deadline of an invocation, which are
+	// then sent by a later invocation of Resume. When nil, such recipients are dropped and the publish fails.
+	Handoff *handoff.Queue
+
+	// Schedule holds the messages which are published at a later time by Dispatch. When nil, a store with the default
+	// options is created from Redis.
+	Schedule *schedule.Store
+
+	// Options configures the Handler. Zero values are replaced by the values of DefaultOptions.
+	Options Options
+}
+
+// Handler handles WebSocket publish requests.
+type Handler struct {
+	redis         redis.Client
+	cfg           aws.Config
+	metrics       *metrics.Emitter
+	acks          *ack.Store
+	history       *channel.History
+	tenants       *tenant.Resolver
+	subscriptions *channel.Subscriptions
+	policy        *policy.Engine
+	users         *user.Limiter
+	fanout        *fanout.Engine
+	handoff       *handoff.Queue
+	scheduled     *schedule.Store
+	closer        *session.Closer
+	opts          Options
+
+	// apiClient provides access to the Amazon API Gateway management functions. Once initialized, the instance is
+	// reused across subsequent AWS Lambda invocations. This potentially amortizes the instance creation over multiple
+	// executions of the AWS Lambda instance.
+	apiClient *apigatewaymanagementapi.Client
+}
+
+// message is a message to publish, along with the details of the request which published it. Scheduled messages are
+// stored in this form until they are due.
+type message struct {
+	Tenant  string           `json:"tenant"`
+	ID      string           `json:"id"`
+	Channel string           `json:"channel"`
+	Input   *ws.InputEnvelop `json:"input"`
+
+	// Sender is the ID of the publishing connection, and Domain and Stage locate the API it is connected to. User is
+	// the ID of the publishing user, if any.
+	Sender string `json:"sender"`
+	User   string `json:"user,omitempty"`
+	Domain string `json:"domain"`
+	Stage  string `json:"stage"`
+
+	// Trace holds the W3C trace context of the publish which scheduled the message.
+	Trace map[string]string `json:"trace,omitempty"`
+}
+
+// NewHandler creates a new Handler from the provided dependencies.
+func NewHandler(deps Dependencies) *Handler {
+	opts := deps.Options
+	if opts.DedupeTTL <= 0 {
+		opts.DedupeTTL = DefaultOptions().DedupeTTL
+	}
+
+	acks := deps.Acks
+	if acks == nil {
+		acks = ack.NewStore(deps.Redis, ack.DefaultOptions())
+	}
+
+	history := deps.History
+	if history == nil {
+		history = channel.NewHistory(deps.Redis, channel.DefaultOptions())
+	}
+
+	tenants := deps.Tenants
+	if tenants == nil {
+		tenants = tenant.NewResolver(deps.Redis, tenant.DefaultOptions())
+	}
+
+	subscriptions := deps.Subscriptions
+	if subscriptions == nil {
+		subscriptions = channel.NewSubscriptions(deps.Redis)
+	}
+
+	users := deps.Users
+	if users == nil {
+		users = user.NewLimiter(deps.Redis, user.DefaultOptions())
+	}
+
+	engine := deps.FanOut
+	if engine == nil {
+		engine = fanout.New(fanout.DefaultOptions())
+	}
+
+	scheduled := deps.Schedule
+	if scheduled == nil {
+		scheduled = schedule.NewStore(deps.Redis, schedule.DefaultOptions())
+	}
+
+	return &Handler{
+		redis:         deps.Redis,
+		cfg:           deps.Config,
+		apiClient:     deps.ManagementAPI,
+		metrics:       deps.Metrics,
+		acks:          acks,
+		history:       history,
+		tenants:       tenants,
+		subscriptions: subscriptions,
+		policy:        deps.Policy,
+		users:         users,
+		fanout:        engine,
+		handoff:       deps.Handoff,
+		scheduled:     scheduled,
+		closer:        session.NewCloser(deps.Redis, tenants, users),
+		opts:          opts,
+	}
+}
+
+// Handle is the hook AWS Lambda calls to invoke the function as an Amazon API Gateway Proxy. This handlers reads the
+// request and echos the request back out to all connected clients. This demonstrates looking up connected clients from
+// the Redis cache and calling the Amazon API Gateway Management API to send data to the connected clients. Requests of
+// the cancel route cancel a scheduled message instead.
+func (h *Handler) Handle(ctx context.Context, req *events.APIGatewayWebsocketProxyRequest) (res apigw.Response, err error) {
+	ctx = logger.ForRequest(ctx, req)
+	log := logger.FromContext(ctx)
+
+	ctx, span := tracing.Tracer().Start(ctx, "websocket publish", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(tracing.RequestAttributes(req)...))
+
+	rec := h.metrics.Recorder()
+	rec.SetDimension(metrics.DimensionStage, req.RequestContext.Stage)
+
+	defer func() { handler.Finish(ctx, span, rec, err) }()
+
+	// Lazily initialize the API Gateway Management client. This enables setting the service's endpoint to our API
+	// endpoint. These values are provided from the synchronous request, thus the client can only be created upon the
+	// first invocation.
+	if h.apiClient == nil {
+		h.apiClient = apigw.NewAPIGatewayManagementClient(&h.cfg, req.RequestContext.DomainName, req.RequestContext.Stage)
+	}
+
+	if req.RequestContext.RouteKey == RouteCancel {
+		span.SetName("websocket " + RouteCancel)
+		return h.cancel(ctx, req, rec)
+	}
+
+	log.Info("websocket publish")
+
+	input, err := new(ws.InputEnvelop).Decode([]byte(req.Body))
+	if err != nil {
+		log.Error("failed to parse client input", zap.Error(err))
+		return apigw.BadRequestResponse(), err
+	}
+
+	name := input.Channel
+	if name == "" {
+		name = channel.Default
+	}
+
+	// Messages are published to channels, patterns can only be subscribed to.
+	err = channel.Validate(name)
+	if err == nil && channel.IsPattern(name) {
+		err = channel.ErrInvalidPattern
+	}
+
+	if err != nil {
+		log.Error("failed to validate client input", zap.String("channel", name), zap.Error(err))
+		return apigw.BadRequestResponse(), err
+	}
+
+	if len(input.Exclude) > maxTargets || len(input.Only) > maxTargets {
+		err = errTooManyTargets
+		log.Error("failed to validate client input", zap.Int("exclude", len(input.Exclude)),
+			zap.Int("only", len(input.Only)), zap.Error(err))
+		return apigw.BadRequestResponse(), err
+	}
+
+	// Scheduled messages are canceled by their ID, so the client must supply it.
+	if input.DeliverAt > 0 && input.ID == "" {
+		err = errMissingID
+		log.Error("failed to validate client input", zap.Int64("deliverAt", input.DeliverAt), zap.Error(err))
+		return apigw.BadRequestResponse(), err
+	}
+
+	if input.ID != "" {
+		if err = ws.ValidateID(input.ID); err != nil {
+			log.Error("failed to validate client input", zap.Int("idLength", len(input.ID)), zap.Error(err))
+			return apigw.BadRequestResponse(), err
+		}
+	}
+
+	if input.TTL < 0 || input.ExpiresAt < 0 {
+		err = errInvalidExpiry
+		log.Error("failed to validate client input", zap.Int64("ttl", input.TTL),
+			zap.Int64("expiresAt", input.ExpiresAt), zap.Error(err))
+		return apigw.BadRequestResponse(), err
+	}
+
+	// Messages are only ever published to the connections of the publisher's tenant.
+	tenantID, res, err := handler.ResolveTenant(ctx, h.tenants, req, rec)
+	if err != nil {
+		return res, err
+	}
+
+	ks := redis.Tenant(tenantID)
+	ctx = logger.With(ctx, zap.String("tenant", tenantID))
+	log = logger.FromContext(ctx)
+	span.SetAttributes(attribute.String("tenant.id", tenantID))
+
+	start := time.Now()
+	allowed, err := h.policy.Authorize(ctx, ks, req.RequestContext.ConnectionID, policy.Publish, name)
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		log.Error("failed to read connection claims from cache", zap.Error(err))
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	if !allowed {
+		log.Info("deny publish by channel policy", zap.String("channel", name))
+		rec.Increment(metrics.ChannelAccessDenied, 1)
+		return apigw.ForbiddenResponse(), nil
+	}
+
+	rec.SetDimension(metrics.DimensionMessageType, strconv.Itoa(input.Type))
+
+	// Link the trace of the publish to the trace of the message's origin, if any, and pass the trace context on to
+	// the receivers of the message.
+	if origin := tracing.Extract(input.Trace); origin.IsValid() {
+		span.AddLink(trace.Link{SpanContext: origin})
+	}
+
+	// Messages without a client supplied ID can not be retried by the client and are assigned a random ID. Messages
+	// with a client supplied ID are only published the first time the ID is seen within the deduplication period.
+	id := input.ID
+	if id == "" {
+		id = ws.NewMessageID()
+	} else {
+		first, err := h.remember(ctx, ks, id, rec)
+		if err != nil {
+			log.Error("failed to record message id", zap.String("messageId", id), zap.Error(err))
+			return apigw.InternalServerErrorResponse(), err
+		}
+
+		if !first {
+			log.Info("skip duplicate message", zap.String("messageId", id))
+			rec.Increment(metrics.DuplicatesSkipped, 1)
+			return apigw.OkResponse(), nil
+		}
+	}
+
+	user, _ := h.users.ID(req)
+	m := message{
+		Tenant:  tenantID,
+		ID:      id,
+		Channel: name,
+		Input:   input,
+		Sender:  req.RequestContext.ConnectionID,
+		User:    user,
+		Domain:  req.RequestContext.DomainName,
+		Stage:   req.RequestContext.Stage,
+	}
+
+	// Messages to deliver at a later time are scheduled, and published by Dispatch once they are due.
+	if input.DeliverAt > time.Now().UnixMilli() {
+		return h.schedule(ctx, m, rec)
+	}
+
+	if err = h.publish(ctx, m, rec); err != nil {
+		// Forget the client supplied ID so the client can retry the message. Recipients which already received the
+		// message receive it again, and detect the duplicate by its ID.
+		h.forget(ctx, ks, input.ID)
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	return apigw.OkResponse(), nil
+}
+
+// publish assigns the message its sequence number, appends it to the history of its channel and sends it to the
+// recipients.
+func (h *Handler) publish(ctx context.Context, m message, rec *metrics.Recorder) error {
+	log := logger.FromContext(ctx)
+	ks := redis.Tenant(m.Tenant)
+	input := m.Input
+
+	// A message which expired before it was published is dropped without assigning it a sequence number.
+	expiresAt := expiry(input, time.Now())
+	if expiresAt > 0 && time.Now().UnixMilli() >= expiresAt {
+		log.Info("skip expired message", zap.String("messageId", m.ID), zap.Int64("expiresAt", expiresAt))
+		rec.Increment(metrics.MessagesExpired, 1)
+		return nil
+	}
+
+	// Assign the message its position within the channel. Receivers order the messages of a channel by their sequence
+	// numbers, as concurrent deliveries and concurrent publishes may arrive out of order.
+	start := time.Now()
+	seq, err := h.history.Next(ctx, ks, m.Channel)
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		log.Error("failed to assign sequence number", zap.String("messageId", m.ID), zap.Error(err))
+		return err
+	}
+
+	ctx = logger.With(ctx, zap.String("messageId", m.ID), zap.String("channel", m.Channel), zap.Int64("seq", seq))
+	log = logger.FromContext(ctx)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("websocket.message_id", m.ID),
+		attribute.String("websocket.channel", m.Channel),
+		attribute.Int64("websocket.seq", seq),
+	)
+
+	output := &ws.OutputEnvelop{
+		ID:        m.ID,
+		Channel:   m.Channel,
+		Seq:       seq,
+		Data:      input.Data,
+		Type:      input.Type,
+		Received:  time.Now().Unix(),
+		Ack:       input.Ack,
+		Trace:     tracing.Inject(ctx),
+		ExpiresAt: expiresAt,
+	}
+
+	data, err := output.Encode()
+	if err != nil {
+		log.Error("failed to encode output", zap.Error(err))
+		return err
+	}
+
+	// Append the message to the history before it is delivered, so that a receiver which sees a later message of the
+	// channel first can fetch it. Messages targeted with an exclude or only list are not appended, as the history is
+	// readable by every connection which may subscribe to the channel, and are reported as missing when fetched.
+	if len(input.Exclude) == 0 && len(input.Only) == 0 {
+		start = time.Now()
+		err = h.history.Append(ctx, ks, m.Channel, seq, data)
+		rec.Since(metrics.RedisLatency, start)
+		if err != nil {
+			log.Error("failed to append message to channel history", zap.Error(err))
+			return err
+		}
+	}
+
+	// Retain the message in acknowledgement mode so that deliveries which are not acknowledged can be redelivered.
+	if input.Ack {
+		var expires time.Time
+		if expiresAt > 0 {
+			expires = time.UnixMilli(expiresAt)
+		}
+
+		if err := h.acks.Retain(ctx, ks, m.ID, data, expires); err != nil {
+			log.Error("failed to retain message for redelivery", zap.Error(err))
+			return err
+		}
+	}
+
+	// Messages of the default channel are sent to every connection of the tenant, and messages of any other channel
+	// to its subscribers.
+	var connections []string
+	var filters map[string]string
+	start = time.Now()
+	if m.Channel == channel.Default {
+		err = redis.DoRead(ctx, h.redis, "SMEMBERS", radix.Cmd(&connections, "SMEMBERS", ks.ConnectionsKey()))
+	} else {
+		connections, filters, err = h.subscribers(ctx, ks, m.Channel)
+	}
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		log.Error("failed to read connections from cache", zap.Error(err))
+		return err
+	}
+
+	log.Info("websocket connections read from cache", zap.Int("connections", len(connections)))
+
+	rec.Observe(metrics.FanOutSize, float64(len(connections)), metrics.Count)
+
+	// Do not send data to the connection which represents the sender if the message was configured to not echo back
+	// the message.
+	recipients := connections
+	if !input.Echo {
+		recipients = make([]string, 0, len(connections))
+		for _, id := range connections {
+			if id != m.Sender {
+				recipients = append(recipients, id)
+			}
+		}
+	}
+
+	// Resolve the user IDs of the exclude and only lists to the connections of the users. The lists are applied by the
+	// workers, so that they also apply to the recipients which are handed off.
+	start = time.Now()
+	exclude, err := h.targets(ctx, ks, input.Exclude)
+	var only []string
+	if err == nil {
+		only, err = h.targets(ctx, ks, input.Only)
+	}
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		log.Error("failed to read user connections from cache", zap.Error(err))
+		return err
+	}
+
+	task := handoff.Task{
+		Tenant:    m.Tenant,
+		MessageID: m.ID,
+		Ack:       input.Ack,
+		Domain:    m.Domain,
+		Stage:     m.Stage,
+		Trace:     output.Trace,
+		Payload:   data,
+		Filters:   filters,
+		Exclude:   exclude,
+		Only:      only,
+		ExpiresAt: expiresAt,
+	}
+
+	return h.send(ctx, task, recipients, rec)
+}
+
+// Resume is the hook AWS Lambda calls to invoke the function with the tasks handed off through the Amazon SQS queue.
+// The payload of each task is sent to its recipients, and recipients which can not be attempted before the deadline
+// of this invocation are handed off again. Tasks whose recipients could not be handed off again are reported as
+// failures of the batch, so that only their messages are received again.
+func (h *Handler) Resume(ctx context.Context, event events.SQSEvent) (res handoff.BatchResponse, err error) {
+	log := logger.FromContext(ctx)
+
+	ctx, span := tracing.Tracer().Start(ctx, "websocket publish resume", trace.WithSpanKind(trace.SpanKindConsumer))
+	rec := h.metrics.Recorder()
+
+	defer func() { handler.Finish(ctx, span, rec, err) }()
+
+	for _, m := range event.Records {
+		task, err := handoff.Decode(m.Body)
+		if err != nil {
+			// Retrying a message which can not be decoded does not help, so it is dropped.
+			log.Error("failed to decode handed off task", zap.String("sqsMessageId", m.MessageId), zap.Error(err))
+			continue
+		}
+
+		if h.apiClient == nil {
+			h.apiClient = apigw.NewAPIGatewayManagementClient(&h.cfg, task.Domain, task.Stage)
+		}
+
+		rec.SetDimension(metrics.DimensionStage, task.Stage)
+		if origin := tracing.Extract(task.Trace); origin.IsValid() {
+			span.AddLink(trace.Link{SpanContext: origin})
+		}
+
+		ctx := logger.With(ctx, zap.String("tenant", task.Tenant), zap.String("messageId", task.MessageID))
+		logger.FromContext(ctx).Info("resume handed off task", zap.Int("recipients", len(task.Recipients)))
+
+		if err := h.send(ctx, task, task.Recipients, rec); err != nil {
+			logger.FromContext(ctx).Error("failed to resume handed off task",
+				zap.String("sqsMessageId", m.MessageId),
+				zap.Error(err))
+
+			res.BatchItemFailures = append(res.BatchItemFailures, handoff.BatchItemFailure{ItemIdentifier: m.MessageId})
+		}
+	}
+
+	return res, nil
+}
+
+// send sends the payload of the task to the recipients on the fan-out engine. The engine stops dispatching recipients
+// shortly before the deadline of the invocation, and the recipients which were not attempted are handed off to a
+// later invocation. An error is returned if they could not be handed off.
+func (h *Handler) send(ctx context.Context, task handoff.Task, recipients []string, rec *metrics.Recorder) error {
+	log := logger.FromContext(ctx)
+
+	// A message which expired is dropped for all recipients, including those handed off by an earlier invocation.
+	if task.Expired(time.Now()) {
+		log.Info("drop expired message", zap.Int("recipients", len(recipients)))
+		rec.Increment(metrics.DeliveriesExpired, float64(len(recipients)))
+		return nil
+	}
+
+	// Parse the filters of the recipients and decode the data of the message once. Recipients whose filter does not
+	// match the data are skipped.
+	var data interface{}
+	filters := make(map[string]*filter.Filter, len(task.Filters))
+	if len(task.Filters) > 0 {
+		var envelope struct {
+			Data json.RawMessage `json:"data"`
+		}
+
+		if err := json.Unmarshal(task.Payload, &envelope); err == nil {
+			_ = json.Unmarshal(envelope.Data, &data)
+		}
+
+		parsed := make(map[string]*filter.Filter)
+		for id, expr := range task.Filters {
+			f, ok := parsed[expr]
+			if !ok {
+				var err error
+				if f, err = filter.Parse(expr); err != nil {
+					// Subscriptions with invalid filters are rejected, so such a filter matches nothing.
+					log.Error("failed to parse subscription filter", zap.String("filter", expr), zap.Error(err))
+				}
+
+				parsed[expr] = f
+			}
+
+			filters[id] = f
+		}
+	}
+
+	// Recipients which are excluded, or which are not among the only recipients, are skipped as well. The skipped
+	// recipients are removed before the fan-out, so that they neither wait for the rate limit nor count as sent.
+	skip := make(map[string]bool, len(task.Exclude))
+	for _, id := range task.Exclude {
+		skip[id] = true
+	}
+
+	var only map[string]bool
+	if len(task.Only) > 0 {
+		only = make(map[string]bool, len(task.Only))
+		for _, id := range task.Only {
+			only[id] = true
+		}
+	}
+
+	targets := make([]string, 0, len(recipients))
+	var excluded, filtered int
+	for _, id := range recipients {
+		switch f, ok := filters[id]; {
+		case skip[id] || (only != nil && !only[id]):
+			excluded++
+		case ok && (f == nil || !f.Match(data)):
+			filtered++
+		default:
+			targets = append(targets, id)
+		}
+	}
+
+	rec.Increment(metrics.DeliveriesExcluded, float64(excluded))
+	rec.Increment(metrics.DeliveriesFiltered, float64(filtered))
+
+	// The engine stops dispatching recipients once the message expires, while the sends in flight are completed on the
+	// context of the invocation.
+	run := ctx
+	if task.ExpiresAt > 0 {
+		var cancel context.CancelFunc
+		run, cancel = context.WithCancel(ctx)
+		defer cancel()
+
+		timer := time.AfterFunc(time.Until(time.UnixMilli(task.ExpiresAt)), cancel)
+		defer timer.Stop()
+	}
+
+	result := h.fanout.Run(run, targets, func(_ context.Context, id string) error {
+		return h.deliver(ctx, task, id, rec)
+	})
+
+	log.Info("websocket message published",
+		zap.Int("recipients", result.Recipients),
+		zap.Int("workers", result.Workers),
+		zap.Int("sent", result.Sent),
+		zap.Int("failed", result.Failed),
+		zap.Int("skipped", result.Skipped+excluded+filtered),
+		zap.Int("remaining", len(result.Remaining)),
+		zap.Duration("throttled", result.Throttled),
+		zap.Duration("duration", result.Duration))
+
+	rec.Observe(metrics.FanOutWorkers, float64(result.Workers), metrics.Count)
+	rec.Observe(metrics.FanOutThroughput, result.Throughput(), metrics.CountPerSecond)
+	rec.Duration(metrics.FanOutThrottled, result.Throttled)
+
+	if len(result.Remaining) == 0 {
+		return nil
+	}
+
+	if task.Expired(time.Now()) {
+		log.Info("drop remaining recipients of expired message", zap.Int("remaining", len(result.Remaining)))
+		rec.Increment(metrics.DeliveriesExpired, float64(len(result.Remaining)))
+		return nil
+	}
+
+	err := errNoHandoff
+	if h.handoff != nil {
+		task.Recipients = result.Remaining
+		start := time.Now()
+		err = h.handoff.Enqueue(ctx, task)
+		rec.Since(metrics.HandoffLatency, start)
+	}
+
+	if err != nil {
+		log.Error("failed to hand off remaining recipients", zap.Int("remaining", len(result.Remaining)), zap.Error(err))
+		rec.Increment(metrics.DeliveriesDropped, float64(len(result.Remaining)))
+		return err
+	}
+
+	log.Info("remaining recipients handed off", zap.Int("remaining", len(result.Remaining)))
+
+	rec.Increment(metrics.DeliveriesHandedOff, float64(len(result.Remaining)))
+	return nil
+}
+
+// deliver sends the payload of the task to a single connection. Transient failures are retried up to maxRetries
+// times, as long as the rate limit allows before the deadline.
+func (h *Handler) deliver(ctx context.Context, task handoff.Task, id string, rec *metrics.Recorder) error {
+	// Publish the data to the connected client via Amazon API Gateway's Management API. If publishing the data results
+	// in an error, the error is classified and passed to a convenience function which acts on the classification. The
+	// convenience function may return the same error if it can not be handled or may return a different error if
+	// attempting the resolution results in an error. Regardless, if an error is returned the only course of action is
+	// to log it.
+	var sendErr error
+	for retry := 0; ; retry++ {
+		// The message may expire while the delivery waits for the workers, the rate limit or its retries.
+		if task.Expired(time.Now()) {
+			rec.Increment(metrics.DeliveriesExpired, 1)
+			return fanout.ErrSkipped
+		}
+
+		var timing apigw.Timing
+		timing, sendErr = apigw.PostToConnection(ctx, h.apiClient, id, task.Payload)
+		timing.Record(rec)
+		if retry == maxRetries || apigw.Classify(sendErr) != apigw.Retry || !sleep(ctx, retryBackoff<<uint(retry)) {
+			break
+		}
+
+		// Each attempt takes a token of the rate limit, as it counts towards the quota of the management API.
+		if h.fanout.Wait(ctx) != nil {
+			break
+		}
+
+		rec.Increment(metrics.DeliveriesRetried, 1)
+	}
+
+	switch apigw.Classify(sendErr) {
+	case apigw.None:
+		rec.Increment(metrics.DeliveriesSucceeded, 1)
+		if task.Ack {
+			h.track(ctx, ack.Delivery{Tenant: task.Tenant, ConnectionID: id, MessageID: task.MessageID})
+		}
+	case apigw.Remove:
+		rec.Increment(metrics.DeliveriesGone, 1)
+	default:
+		rec.Increment(metrics.DeliveriesFailed, 1)
+	}
+
+	if err := h.handleError(ctx, sendErr, redis.Tenant(task.Tenant), id, rec); err != nil {
+		logger.Sampled(ctx).Error("failed to publish to connection", zap.String("receiver", id), zap.Error(err))
+	}
+
+	return sendErr
+}
+
+// subscribers returns the connections subscribed to the channel itself, and those subscribed to a pattern which
+// matches the channel, along with the filter expressions of the recipients whose subscriptions are filtered. A pattern
+// subscription was authorized for every channel the pattern matches when it was made, see policy.Engine.Authorize.
+func (h *Handler) subscribers(ctx context.Context, ks redis.Keyspace, name string) ([]string, map[string]string,
+	error) {
+	subscribers, err := h.subscriptions.Subscribers(ctx, ks, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	matched, err := h.subscriptions.PatternSubscribers(ctx, ks, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	subscribers = channel.Merge(subscribers, matched)
+	connections := make([]string, len(subscribers))
+	var filters map[string]string
+	for i, sub := range subscribers {
+		connections[i] = sub.ConnectionID
+		if sub.Filter != "" {
+			if filters == nil {
+				filters = make(map[string]string)
+			}
+
+			filters[sub.ConnectionID] = sub.Filter
+		}
+	}
+
+	return connections, filters, nil
+}
+
+// expiry returns the time at which the message expires in Unix milliseconds, the earlier of its TTL counted from the
+// provided time and its expiry, or zero if it does not expire.
+func expiry(input *ws.InputEnvelop, now time.Time) int64 {
+	expiresAt := input.ExpiresAt
+	if input.TTL > 0 {
+		if at := now.UnixMilli() + input.TTL; expiresAt == 0 || at < expiresAt {
+			expiresAt = at
+		}
+	}
+
+	return expiresAt
+}
+
+// targets resolves the IDs of an exclude or only list to the IDs of connections. Each ID is taken both as the ID of a
+// connection and as the ID of a user, whose connections are added as well.
+func (h *Handler) targets(ctx context.Context, ks redis.Keyspace, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool, len(ids))
+	connections := make([]string, 0, len(ids))
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			connections = append(connections, id)
+		}
+	}
+
+	for _, id := range ids {
+		add(id)
+
+		owned, err := h.users.Connections(ctx, ks, id)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range owned {
+			add(c)
+		}
+	}
+
+	return connections, nil
+}
+
+// sleep waits for the provided duration. It reports false if the context was done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// track records a delivery in acknowledgement mode. Failures are logged, as the delivery was already made and the only
+// consequence is that it will not be redelivered.
+func (h *Handler) track(ctx context.Context, d ack.Delivery) {
+	if err := h.acks.Track(ctx, d); err != nil {
+		logger.Sampled(ctx).Error("failed to track delivery", zap.String("receiver", d.ConnectionID), zap.Error(err))
+	}
+}
+
+// remember records the provided message ID for the deduplication period. It reports whether the ID was seen for the
+// first time within that period.
+func (h *Handler) remember(ctx context.Context, ks redis.Keyspace, id string, rec *metrics.Recorder) (bool, error) {
+	// SET with NX replies with nil when the key already exists.
+	var reply radix.MaybeNil
+	ttl := strconv.FormatInt(int64(h.opts.DedupeTTL/time.Millisecond), 10)
+	start := time.Now()
+	err := redis.Do(ctx, h.redis, "SET", radix.Cmd(&reply, "SET", ks.MessageKey(id, "seen"), "1", "NX", "PX", ttl))
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		return false, err
+	}
+
+	return !reply.Nil, nil
+}
+
+// forget deletes the client supplied message ID recorded by remember, if any, so the client can retry the message.
+// Failures are logged, as the ID expires regardless.
+func (h *Handler) forget(ctx context.Context, ks redis.Keyspace, id string) {
+	if id == "" {
+		return
+	}
+
+	err := redis.Do(ctx, h.redis, "DEL", radix.Cmd(nil, "DEL", ks.MessageKey(id, "seen")))
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to forget message id", zap.Error(err))
+	}
+}
+
+// handleError is a convenience function for taking action for a given error value. The function handles nil errors as a
+// convenience to the caller. If a nil error is provided, the error is immediately returned. The action is chosen by
+// the classification of the error: connections which no longer exist are deleted from the cache, and failures which
+// require the attention of an operator are counted and logged as alerts. The function may return an error from the
+// handling action, such as deleting the id from the cache, if that action results in an error.
+func (h *Handler) handleError(ctx context.Context, err error, ks redis.Keyspace, id string,
+	rec *metrics.Recorder) error {
+	if err == nil {
+		return err
+	}
+
+	switch decision := apigw.Classify(err); decision {
+	case apigw.Remove:
+		logger.Sampled(ctx).Info("delete stale connection details from cache", zap.String("receiver", id))
+		return h.deleteConnectionId(ctx, ks, id, rec)
+	case apigw.Alert:
+		// Not sampled, as the failure affects every connection and must not be missed.
+		logger.FromContext(ctx).Error("publish rejected by the management api",
+			zap.String("receiver", id),
+			zap.Stringer("decision", decision),
+			zap.Error(err))
+
+		rec.Increment(metrics.DeliveryAlerts, 1)
+		return nil
+	default:
+		// Retries were exhausted, or the message can not be delivered to the healthy connection.
+		return err
+	}
+}
+
+// deleteConnectionId removes the state of a connection which is gone from the REDIS cache, the same way as the
+// disconnect handler does, as the disconnect route may never have been invoked for it. The function logs both error and
+// success cases.
+func (h *Handler) deleteConnectionId(ctx context.Context, ks redis.Keyspace, id string, rec *metrics.Recorder) error {
+	start := time.Now()
+	removed, err := h.closer.Cleanup(ctx, ks, id)
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		logger.Sampled(ctx).Error("failed to delete connection details from cache",
+			zap.String("receiver", id),
+			zap.Error(err))
+
+		return err
+	}
+
+	logger.Sampled(ctx).Info("websocket connection deleted from cache",
+		zap.Bool("removed", removed),
+		zap.String("receiver", id))
+
+	if removed {
+		rec.Increment(metrics.ConnectionsRemoved, 1)
+	}
+
+	return nil
+}
@@ -0,0 +1,329 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw/ws"
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis/redistest"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+)
+
+var ks = redis.Tenant("acme")
+
+// managementAPI emulates the PostToConnection action of the Amazon API Gateway Management API. Connections in gone no
+// longer exist, and the messages posted to the other connections are recorded.
+type managementAPI struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	gone  map[string]bool
+	posts map[string][]ws.OutputEnvelop
+}
+
+func newManagementAPI(t *testing.T, gone ...string) *managementAPI {
+	api := &managementAPI{gone: make(map[string]bool), posts: make(map[string][]ws.OutputEnvelop)}
+	for _, id := range gone {
+		api.gone[id] = true
+	}
+
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/@connections/")
+		if api.gone[id] {
+			w.Header().Set("x-amzn-ErrorType", apigatewaymanagementapi.ErrCodeGoneException)
+			w.WriteHeader(http.StatusGone)
+			io.WriteString(w, `{"message":"gone"}`)
+			return
+		}
+
+		var output ws.OutputEnvelop
+		if err := json.NewDecoder(r.Body).Decode(&output); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		api.mu.Lock()
+		api.posts[id] = append(api.posts[id], output)
+		api.mu.Unlock()
+	}))
+	t.Cleanup(api.Close)
+
+	return api
+}
+
+// client returns a client of the Management API.
+func (api *managementAPI) client() *apigatewaymanagementapi.Client {
+	cfg := defaults.Config()
+	cfg.Region = "us-east-1"
+	cfg.Credentials = aws.NewStaticCredentialsProvider("AKID", "SECRET", "")
+	cfg.EndpointResolver = aws.ResolveWithEndpointURL(api.URL)
+	return apigatewaymanagementapi.New(cfg)
+}
+
+// received returns the IDs of the connections which received a message, in order.
+func (api *managementAPI) received() []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	ids := make([]string, 0, len(api.posts))
+	for id := range api.posts {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+// newHandler returns a Handler backed by an in-memory client and the Management API, with conn1 connected to the acme
+// tenant.
+func newHandler(api *managementAPI) (*Handler, *redistest.Store, *metrics.MemorySink) {
+	client := redistest.NewStore()
+	client.Strings[redis.ConnectionTenantKey("conn1")] = "acme"
+
+	sink := &metrics.MemorySink{}
+	h := NewHandler(Dependencies{
+		Redis:         client,
+		ManagementAPI: api.client(),
+		Metrics:       metrics.NewEmitter("Test", sink),
+	})
+
+	return h, client, sink
+}
+
+// request returns a publish request of conn1 with the provided body.
+func request(body string) *events.APIGatewayWebsocketProxyRequest {
+	return &events.APIGatewayWebsocketProxyRequest{
+		Body: body,
+		RequestContext: events.APIGatewayWebsocketProxyRequestContext{
+			ConnectionID: "conn1",
+			RouteKey:     "publish",
+			DomainName:   "example.com",
+			Stage:        "dev",
+		},
+	}
+}
+
+func TestHandle(t *testing.T) {
+	api := newManagementAPI(t, "conn3")
+	h, client, sink := newHandler(api)
+	client.Sets[ks.ConnectionsKey()] = map[string]bool{"conn1": true, "conn2": true, "conn3": true}
+
+	res, err := h.Handle(context.Background(), request(`{"data":{"hello":"world"},"type":1}`))
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Handle returned %d, %v, want %d", res.StatusCode, err, http.StatusOK)
+	}
+
+	// The message is not echoed to the sender, and the connection which is gone is removed.
+	if got, want := api.received(), []string{"conn2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got messages for %v, want %v", got, want)
+	}
+
+	output := api.posts["conn2"][0]
+	if output.Channel != "default" || output.Seq != 1 || string(output.Data) != `{"hello":"world"}` || output.ID == "" {
+		t.Errorf("got message %+v, want the data with sequence number 1 of the default channel", output)
+	}
+
+	connections := client.Sets[ks.ConnectionsKey()]
+	if want := map[string]bool{"conn1": true, "conn2": true}; !reflect.DeepEqual(connections, want) {
+		t.Errorf("got connections %v, want %v", connections, want)
+	}
+
+	if got := client.SortedSet(ks.ChannelKey("default", "history")); len(got) != 1 {
+		t.Errorf("got %d messages in the history, want 1", len(got))
+	}
+
+	for name, want := range map[string]float64{
+		metrics.DeliveriesSucceeded: 1,
+		metrics.DeliveriesGone:      1,
+		metrics.ConnectionsRemoved:  1,
+	} {
+		if got := sink.Sum(name); got != want {
+			t.Errorf("got %s %v, want %v", name, got, want)
+		}
+	}
+
+	if got := sink.Values(metrics.FanOutSize); !reflect.DeepEqual(got, []float64{3}) {
+		t.Errorf("got %s %v, want [3]", metrics.FanOutSize, got)
+	}
+}
+
+func TestHandleSubscribers(t *testing.T) {
+	api := newManagementAPI(t)
+	h, client, sink := newHandler(api)
+	client.Sets[ks.ChannelKey("news", "subscribers")] = map[string]bool{"conn2": true, "conn3": true, "conn4": true}
+	client.Hashes[ks.ChannelKey("news", "filters")] = map[string]string{
+		"conn3": `region == "eu"`,
+		"conn4": `region == "us"`,
+	}
+
+	// conn5 receives the message through its pattern subscription, and conn4 as its pattern subscription is not
+	// filtered.
+	client.Sets[ks.Key("patterns", "prefix", "news")] = map[string]bool{"news.#": true}
+	client.Sets[ks.ChannelKey("news.#", "subscribers")] = map[string]bool{"conn4": true, "conn5": true}
+	client.Sets[ks.Key("patterns", "prefix", "")] = map[string]bool{"*.eu": true}
+	client.Sets[ks.ChannelKey("*.eu", "subscribers")] = map[string]bool{"conn6": true}
+
+	body := `{"channel":"news","data":{"region":"eu"},"exclude":["conn2"]}`
+	res, err := h.Handle(context.Background(), request(body))
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("Handle returned %d, %v, want %d", res.StatusCode, err, http.StatusOK)
+	}
+
+	if got, want := api.received(), []string{"conn3", "conn4", "conn5"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got messages for %v, want %v", got, want)
+	}
+
+	// Messages targeted with an exclude list are not kept in the history.
+	if got := client.SortedSet(ks.ChannelKey("news", "history")); len(got) != 0 {
+		t.Errorf("got %d messages in the history, want none", len(got))
+	}
+
+	for name, want := range map[string]float64{
+		metrics.DeliveriesSucceeded: 3,
+		metrics.DeliveriesExcluded:  1,
+		metrics.DeliveriesFiltered:  0,
+	} {
+		if got := sink.Sum(name); got != want {
+			t.Errorf("got %s %v, want %v", name, got, want)
+		}
+	}
+
+	// Without the pattern subscription, the filter of conn4 applies.
+	delete(client.Sets, ks.Key("patterns", "prefix", "news"))
+	if _, err := h.Handle(context.Background(), request(`{"channel":"news","data":{"region":"eu"}}`)); err != nil {
+		t.Fatalf("Handle returned error: %v", err)
+	}
+
+	if got := sink.Sum(metrics.DeliveriesFiltered); got != 1 {
+		t.Errorf("got %s %v, want 1", metrics.DeliveriesFiltered, got)
+	}
+}
+
+func TestHandleDuplicate(t *testing.T) {
+	api := newManagementAPI(t)
+	h, client, sink := newHandler(api)
+	client.Sets[ks.ConnectionsKey()] = map[string]bool{"conn1": true, "conn2": true}
+
+	for i := 0; i < 2; i++ {
+		res, err := h.Handle(context.Background(), request(`{"id":"m1","data":"hello"}`))
+		if err != nil || res.StatusCode != http.StatusOK {
+			t.Fatalf("Handle returned %d, %v, want %d", res.StatusCode, err, http.StatusOK)
+		}
+	}
+
+	if got := len(api.posts["conn2"]); got != 1 {
+		t.Errorf("got %d messages, want 1", got)
+	}
+
+	if got := sink.Sum(metrics.DuplicatesSkipped); got != 1 {
+		t.Errorf("got %s %v, want 1", metrics.DuplicatesSkipped, got)
+	}
+}
+
+func TestHandleNoHandoff(t *testing.T) {
+	api := newManagementAPI(t)
+	h, client, sink := newHandler(api)
+	client.Sets[ks.ConnectionsKey()] = map[string]bool{"conn1": true, "conn2": true}
+
+	// The deadline is within the deadline margin, so no recipient is attempted.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	res, err := h.Handle(ctx, request(`{"id":"m1","data":"hello"}`))
+	if err != errNoHandoff || res.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Handle returned %d, %v, want %d", res.StatusCode, err, http.StatusInternalServerError)
+	}
+
+	if got := api.received(); len(got) != 0 {
+		t.Errorf("got messages for %v, want none", got)
+	}
+
+	if got := sink.Sum(metrics.DeliveriesDropped); got != 1 {
+		t.Errorf("got %s %v, want 1", metrics.DeliveriesDropped, got)
+	}
+
+	// The ID is forgotten, so that the client can retry the message.
+	if _, ok := client.Strings[ks.MessageKey("m1", "seen")]; ok {
+		t.Error("ID of the failed message was not forgotten")
+	}
+}
+
+func TestHandleInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"malformed", `{"data":`, http.StatusBadRequest},
+		{"pattern", `{"channel":"news.*","data":1}`, http.StatusBadRequest},
+		{"too many targets", `{"data":1,"only":[` + strings.Repeat(`"conn2",`, maxTargets) + `"conn2"]}`,
+			http.StatusBadRequest},
+		{"schedule without id", `{"data":1,"deliverAt":4102444800000}`, http.StatusBadRequest},
+		{"invalid id", `{"id":"m 1","data":1}`, http.StatusBadRequest},
+		{"negative ttl", `{"data":1,"ttl":-1}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newManagementAPI(t)
+			h, client, _ := newHandler(api)
+			client.Sets[ks.ConnectionsKey()] = map[string]bool{"conn1": true, "conn2": true}
+
+			res, err := h.Handle(context.Background(), request(tt.body))
+			if err == nil || res.StatusCode != tt.status {
+				t.Errorf("Handle returned %d, %v, want %d", res.StatusCode, err, tt.status)
+			}
+
+			if got := api.received(); len(got) != 0 {
+				t.Errorf("got messages for %v, want none", got)
+			}
+		})
+	}
+}
+
+func TestHandleUnknownConnection(t *testing.T) {
+	api := newManagementAPI(t)
+	h, client, _ := newHandler(api)
+	delete(client.Strings, redis.ConnectionTenantKey("conn1"))
+
+	res, err := h.Handle(context.Background(), request(`{"data":1}`))
+	if err == nil || res.StatusCode != http.StatusForbidden {
+		t.Errorf("Handle returned %d, %v, want %d", res.StatusCode, err, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,63 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package publish
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Environment variables read by OptionsFromEnv.
+const (
+	EnvDedupeTTL = "PUBLISH_DEDUPE_TTL"
+)
+
+// Options configures the Handler's behavior.
+type Options struct {
+	// DedupeTTL is how long the ID of a published message is remembered. A message published again with the same ID
+	// within this period is skipped.
+	DedupeTTL time.Duration
+}
+
+// DefaultOptions returns the Options used when no environment variables are set.
+func DefaultOptions() Options {
+	return Options{
+		DedupeTTL: 5 * time.Minute,
+	}
+}
+
+// OptionsFromEnv returns DefaultOptions overridden by any of the PUBLISH_* environment variables which are set.
+func OptionsFromEnv() (Options, error) {
+	opts := DefaultOptions()
+	if v := os.Getenv(EnvDedupeTTL); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			return opts, fmt.Errorf("invalid %s %q: must be a duration of at least 1s", EnvDedupeTTL, v)
+		}
+
+		opts.DedupeTTL = d
+	}
+
+	return opts, nil
+}
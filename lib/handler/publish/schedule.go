@@ -0,0 +1,288 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw"
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw/ws"
+	"com.aws-samples/apigateway.websockets.golang/lib/handler"
+	"com.aws-samples/apigateway.websockets.golang/lib/logger"
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/policy"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"com.aws-samples/apigateway.websockets.golang/lib/schedule"
+	"com.aws-samples/apigateway.websockets.golang/lib/tracing"
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// dispatchBatchSize is the number of due scheduled messages read from the cache at once.
+const dispatchBatchSize = 100
+
+// errMissingID is returned when a scheduled message has no client supplied ID, or a cancel request does not name the
+// message to cancel.
+var errMissingID = errors.New("missing message id")
+
+// schedule stores the message until it is due, when it is published by Dispatch.
+func (h *Handler) schedule(ctx context.Context, m message, rec *metrics.Recorder) (apigw.Response, error) {
+	log := logger.FromContext(ctx).With(zap.String("messageId", m.ID), zap.Int64("deliverAt", m.Input.DeliverAt))
+	ks := redis.Tenant(m.Tenant)
+
+	m.Trace = tracing.Inject(ctx)
+	payload, err := json.Marshal(m)
+	if err != nil {
+		log.Error("failed to encode scheduled message", zap.Error(err))
+		h.forget(ctx, ks, m.Input.ID)
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	start := time.Now()
+	err = h.scheduled.Add(ctx, schedule.Entry{Tenant: m.Tenant, MessageID: m.ID}, time.UnixMilli(m.Input.DeliverAt),
+		payload)
+	rec.Since(metrics.RedisLatency, start)
+	switch {
+	case err == schedule.ErrTooLate:
+		log.Error("failed to validate client input", zap.Error(err))
+		h.forget(ctx, ks, m.Input.ID)
+		return apigw.BadRequestResponse(), err
+	case err != nil:
+		log.Error("failed to cache scheduled message", zap.Error(err))
+		h.forget(ctx, ks, m.Input.ID)
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	log.Info("websocket message scheduled")
+
+	rec.Increment(metrics.MessagesScheduled, 1)
+	return apigw.OkResponse(), nil
+}
+
+// Dispatch is the hook AWS Lambda calls to invoke the function on a schedule. The scheduled messages which are due are
+// published the same way as the messages published by Handle, with recipients which can not be attempted before the
+// deadline of this invocation handed off to Resume.
+func (h *Handler) Dispatch(ctx context.Context, _ events.CloudWatchEvent) (err error) {
+	log := logger.FromContext(ctx)
+
+	ctx, span := tracing.Tracer().Start(ctx, "websocket publish dispatch", trace.WithSpanKind(trace.SpanKindConsumer))
+	rec := h.metrics.Recorder()
+
+	defer func() { handler.Finish(ctx, span, rec, err) }()
+
+	// Dispatched messages are removed from the schedule, and messages which failed to publish are leased until after
+	// now, so each batch holds new messages until all due messages were processed.
+	now := time.Now()
+	for ctx.Err() == nil {
+		start := time.Now()
+		due, err := h.scheduled.Due(ctx, now, dispatchBatchSize)
+		rec.Since(metrics.RedisLatency, start)
+		if err != nil {
+			log.Error("failed to read due messages from cache", zap.Error(err))
+			return err
+		}
+
+		for _, e := range due {
+			if err := h.dispatch(ctx, e, rec); err != nil {
+				return err
+			}
+		}
+
+		if len(due) < dispatchBatchSize {
+			return nil
+		}
+	}
+
+	return ctx.Err()
+}
+
+// dispatch claims the scheduled message, publishes it and completes it. Messages which were claimed by a concurrent
+// invocation or canceled in the meantime are skipped. A message which fails to publish is logged and left to be
+// claimed again once its lease expired. Only failures of the schedule itself are returned, as they would otherwise be
+// repeated for the following messages.
+func (h *Handler) dispatch(ctx context.Context, e schedule.Entry, rec *metrics.Recorder) error {
+	ctx = logger.With(ctx, zap.String("tenant", e.Tenant), zap.String("messageId", e.MessageID))
+	log := logger.FromContext(ctx)
+
+	start := time.Now()
+	payload, ok, err := h.scheduled.Claim(ctx, e, start)
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		log.Error("failed to claim scheduled message", zap.Error(err))
+		return err
+	}
+
+	if !ok {
+		return nil
+	}
+
+	var m message
+	if err := json.Unmarshal(payload, &m); err != nil || m.Input == nil {
+		// Dispatching a message which can not be decoded again does not help, so it is dropped.
+		log.Error("failed to decode scheduled message", zap.Error(err))
+		return h.complete(ctx, e, rec)
+	}
+
+	if h.apiClient == nil {
+		h.apiClient = apigw.NewAPIGatewayManagementClient(&h.cfg, m.Domain, m.Stage)
+	}
+
+	rec.SetDimension(metrics.DimensionStage, m.Stage)
+	if origin := tracing.Extract(m.Trace); origin.IsValid() {
+		trace.SpanFromContext(ctx).AddLink(trace.Link{SpanContext: origin})
+	}
+
+	delay := time.Since(time.UnixMilli(m.Input.DeliverAt))
+	log.Info("dispatch scheduled message", zap.Duration("delay", delay))
+	rec.Duration(metrics.DispatchDelay, delay)
+
+	if err := h.publish(ctx, m, rec); err != nil {
+		log.Error("failed to publish scheduled message, retry after lease", zap.Error(err))
+		return nil
+	}
+
+	if err := h.complete(ctx, e, rec); err != nil {
+		return err
+	}
+
+	rec.Increment(metrics.MessagesDispatched, 1)
+	return nil
+}
+
+// complete removes the dispatched message from the schedule.
+func (h *Handler) complete(ctx context.Context, e schedule.Entry, rec *metrics.Recorder) error {
+	start := time.Now()
+	err := h.scheduled.Complete(ctx, e)
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to complete scheduled message", zap.Error(err))
+	}
+
+	return err
+}
+
+// cancel handles the requests of the cancel route. The scheduled message is canceled if it was scheduled by the same
+// connection or user, and the connection may publish to its channel. The client is sent a CancellationEnvelop with
+// the outcome of the request.
+func (h *Handler) cancel(ctx context.Context, req *events.APIGatewayWebsocketProxyRequest,
+	rec *metrics.Recorder) (apigw.Response, error) {
+	log := logger.FromContext(ctx)
+
+	log.Info("websocket cancel")
+
+	input, err := new(ws.CancelEnvelop).Decode([]byte(req.Body))
+	if err == nil && input.ID == "" {
+		err = errMissingID
+	} else if err == nil {
+		err = ws.ValidateID(input.ID)
+	}
+
+	if err != nil {
+		log.Error("failed to parse client cancel request", zap.Error(err))
+		return apigw.BadRequestResponse(), err
+	}
+
+	tenantID, res, err := handler.ResolveTenant(ctx, h.tenants, req, rec)
+	if err != nil {
+		return res, err
+	}
+
+	log = log.With(zap.String("tenant", tenantID), zap.String("messageId", input.ID))
+	e := schedule.Entry{Tenant: tenantID, MessageID: input.ID}
+
+	start := time.Now()
+	payload, ok, err := h.scheduled.Load(ctx, e)
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		log.Error("failed to read scheduled message from cache", zap.Error(err))
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	reply := &ws.CancellationEnvelop{Message: "unknown", ID: input.ID}
+	res = apigw.OkResponse()
+	if ok {
+		var m message
+		if err := json.Unmarshal(payload, &m); err != nil {
+			log.Error("failed to decode scheduled message", zap.Error(err))
+			return apigw.InternalServerErrorResponse(), err
+		}
+
+		// Only the client which scheduled the message may cancel it, either from the same connection or from any
+		// connection of the same user.
+		allowed := h.owns(req, m)
+
+		start = time.Now()
+		if allowed {
+			allowed, err = h.policy.Authorize(ctx, redis.Tenant(tenantID), req.RequestContext.ConnectionID,
+				policy.Publish, m.Channel)
+		}
+		if err == nil && allowed {
+			ok, err = h.scheduled.Cancel(ctx, e)
+		}
+		rec.Since(metrics.RedisLatency, start)
+		switch {
+		case err != nil:
+			log.Error("failed to cancel scheduled message", zap.Error(err))
+			return apigw.InternalServerErrorResponse(), err
+		case !allowed:
+			log.Info("deny cancel of scheduled message", zap.String("channel", m.Channel))
+			reply.Message = "denied"
+			res = apigw.ForbiddenResponse()
+			rec.Increment(metrics.ChannelAccessDenied, 1)
+		case ok:
+			log.Info("scheduled message canceled")
+			reply.Message = "canceled"
+			rec.Increment(metrics.MessagesCanceled, 1)
+		}
+	}
+
+	data, err := reply.Encode()
+	if err != nil {
+		log.Error("failed to encode output", zap.Error(err))
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	timing, err := apigw.PostToConnection(ctx, h.apiClient, req.RequestContext.ConnectionID, data)
+	timing.Record(rec)
+	if err != nil {
+		log.Error("failed to send cancel reply", zap.Error(err))
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	return res, nil
+}
+
+// owns reports whether the request comes from the connection which scheduled the message, or from a connection of the
+// same user.
+func (h *Handler) owns(req *events.APIGatewayWebsocketProxyRequest, m message) bool {
+	if req.RequestContext.ConnectionID == m.Sender {
+		return true
+	}
+
+	id, ok := h.users.ID(req)
+	return ok && m.User != "" && id == m.User
+}
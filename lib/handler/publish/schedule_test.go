@@ -0,0 +1,117 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw/ws"
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis/redistest"
+	"com.aws-samples/apigateway.websockets.golang/lib/schedule"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// leaseScript emulates the script with which the schedule leases the entries it claims.
+func leaseScript(client *redistest.Store) func(string, []string, []string) interface{} {
+	return func(_ string, keys, args []string) interface{} {
+		score, ok := client.ZSets[keys[0]][args[0]]
+		now, _ := strconv.ParseFloat(args[1], 64)
+		if !ok || score > now {
+			return 0
+		}
+
+		client.ZSets[keys[0]][args[0]], _ = strconv.ParseFloat(args[2], 64)
+		return 1
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	client := redistest.NewStore()
+	client.Eval = leaseScript(client)
+
+	// Assigning sequence numbers fails, so that messages which are not dropped before fail to publish.
+	client.Fail["INCR"] = errors.New("ERR unavailable")
+
+	store := schedule.NewStore(client, schedule.Options{Lease: time.Minute})
+	ctx := context.Background()
+	now := time.Now()
+	add := func(id string, payload []byte) schedule.Entry {
+		e := schedule.Entry{Tenant: "acme", MessageID: id}
+		if err := store.Add(ctx, e, now.Add(-time.Second), payload); err != nil {
+			t.Fatalf("Add returned error: %v", err)
+		}
+
+		return e
+	}
+
+	encode := func(input *ws.InputEnvelop) []byte {
+		payload, err := json.Marshal(message{Tenant: "acme", ID: input.ID, Channel: "news", Input: input,
+			Domain: "example.com", Stage: "dev"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return payload
+	}
+
+	past := now.Add(-time.Minute).UnixMilli()
+	expired := add("expired", encode(&ws.InputEnvelop{ID: "expired", DeliverAt: past, ExpiresAt: past}))
+	failed := add("failed", encode(&ws.InputEnvelop{ID: "failed", DeliverAt: past}))
+	invalid := add("invalid", []byte("not json"))
+
+	sink := &metrics.MemorySink{}
+	h := NewHandler(Dependencies{Redis: client, Schedule: store, Metrics: metrics.NewEmitter("Test", sink)})
+	if err := h.Dispatch(ctx, events.CloudWatchEvent{}); err != nil {
+		t.Fatalf("Dispatch returned error: %v", err)
+	}
+
+	// The expired message is published by dropping it, and the invalid message is dropped as well. The message which
+	// failed to publish stays scheduled until the end of its lease.
+	for _, e := range []schedule.Entry{expired, invalid} {
+		if _, ok, _ := store.Load(ctx, e); ok {
+			t.Errorf("message %s is still scheduled", e.MessageID)
+		}
+	}
+
+	if _, ok, _ := store.Load(ctx, failed); !ok {
+		t.Error("message which failed to publish was dropped")
+	}
+
+	if due, err := store.Due(ctx, now, 10); err != nil || len(due) != 0 {
+		t.Errorf("Due returned %v, %v, want no entries before the lease expired", due, err)
+	}
+
+	if due, err := store.Due(ctx, now.Add(2*time.Minute), 10); err != nil || len(due) != 1 || due[0] != failed {
+		t.Errorf("Due returned %v, %v, want %v after the lease expired", due, err, failed)
+	}
+
+	if got := sink.Sum(metrics.MessagesDispatched); got != 1 {
+		t.Errorf("MessagesDispatched = %v, want 1", got)
+	}
+}
@@ -0,0 +1,183 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package redeliver provides the handler invoked on a schedule to redeliver messages which were published in
+// acknowledgement mode and were not acknowledged by their recipients in time.
+package redeliver
+
+import (
+	"context"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/ack"
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw"
+	"com.aws-samples/apigateway.websockets.golang/lib/handler"
+	"com.aws-samples/apigateway.websockets.golang/lib/logger"
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"com.aws-samples/apigateway.websockets.golang/lib/session"
+	"com.aws-samples/apigateway.websockets.golang/lib/tracing"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// batchSize is the number of due deliveries read from the cache at once.
+const batchSize = 500
+
+// Dependencies holds the store of the pending deliveries and the client of the API they are redelivered through.
+type Dependencies struct {
+	Acks *ack.Store
+
+	// Closer removes the state of the connections found gone. When nil, their state is left to the disconnect handler.
+	Closer *session.Closer
+
+	// ManagementAPI is the Amazon API Gateway Management API client configured with the endpoint of the application's
+	// API. Unlike the handlers invoked by Amazon API Gateway, this handler can not derive the endpoint from the request.
+	ManagementAPI *apigatewaymanagementapi.Client
+
+	// Metrics creates the recorder for the metrics of each invocation. A nil Emitter discards all metrics.
+	Metrics *metrics.Emitter
+}
+
+// Handler redelivers unacknowledged messages.
+type Handler struct {
+	acks      *ack.Store
+	closer    *session.Closer
+	apiClient *apigatewaymanagementapi.Client
+	metrics   *metrics.Emitter
+}
+
+// NewHandler creates a new Handler from the provided dependencies.
+func NewHandler(deps Dependencies) *Handler {
+	return &Handler{acks: deps.Acks, closer: deps.Closer, apiClient: deps.ManagementAPI, metrics: deps.Metrics}
+}
+
+// Handle receives a scheduled invocation and redelivers all deliveries which are due. Deliveries which reached the
+// maximum number of attempts, whose message expired, or whose connection is gone are moved to the dead-letter list.
+func (h *Handler) Handle(ctx context.Context, _ events.CloudWatchEvent) (err error) {
+	log := logger.FromContext(ctx)
+
+	ctx, span := tracing.Tracer().Start(ctx, "redeliver", trace.WithSpanKind(trace.SpanKindConsumer))
+	rec := h.metrics.Recorder()
+
+	defer func() { handler.Finish(ctx, span, rec, err) }()
+
+	// Deliveries which are attempted are rescheduled into the future, so each batch holds new deliveries until all due
+	// deliveries were processed.
+	now := time.Now()
+	for ctx.Err() == nil {
+		start := time.Now()
+		due, err := h.acks.Due(ctx, now, batchSize)
+		rec.Since(metrics.RedisLatency, start)
+		if err != nil {
+			log.Error("failed to read due deliveries from cache", zap.Error(err))
+			return err
+		}
+
+		for _, d := range due {
+			h.redeliver(ctx, d, rec)
+		}
+
+		if len(due) < batchSize {
+			return nil
+		}
+	}
+
+	return ctx.Err()
+}
+
+// redeliver attempts the delivery again. Errors are logged, as the delivery is retried on the next invocation.
+func (h *Handler) redeliver(ctx context.Context, d ack.Delivery, rec *metrics.Recorder) {
+	log := logger.Sampled(ctx).With(zap.String("receiver", d.ConnectionID), zap.String("messageId", d.MessageID))
+
+	attempt, payload, ok, err := h.acks.Next(ctx, d)
+	if err != nil {
+		log.Error("failed to prepare redelivery", zap.Error(err))
+		return
+	}
+
+	if !ok {
+		if attempt > 0 {
+			log.Info("delivery moved to dead-letter list", zap.Int("attempts", attempt))
+			rec.Increment(metrics.DeadLettered, 1)
+		}
+
+		return
+	}
+
+	timing, err := apigw.PostToConnection(ctx, h.apiClient, d.ConnectionID, payload)
+	timing.Record(rec)
+	rec.Increment(metrics.Redeliveries, 1)
+	// Deliveries which failed transiently are attempted again on their next schedule. Deliveries which can not succeed
+	// are moved to the dead-letter list right away.
+	reason := ""
+	switch decision := apigw.Classify(err); decision {
+	case apigw.None:
+		log.Info("message redelivered", zap.Int("attempt", attempt))
+	case apigw.Remove:
+		reason = ack.ReasonGone
+		h.cleanup(ctx, d, rec)
+	case apigw.Drop:
+		reason = ack.ReasonRejected
+	default:
+		log.Error("failed to redeliver message",
+			zap.Int("attempt", attempt),
+			zap.Stringer("decision", decision),
+			zap.Error(err))
+	}
+
+	if reason == "" {
+		return
+	}
+
+	if err := h.acks.DeadLetter(ctx, d, attempt, reason); err != nil {
+		log.Error("failed to move delivery to dead-letter list", zap.Error(err))
+		return
+	}
+
+	log.Info("delivery moved to dead-letter list", zap.Int("attempts", attempt), zap.String("reason", reason))
+	rec.Increment(metrics.DeadLettered, 1)
+}
+
+// cleanup removes the state of the gone connection from the REDIS cache, as the disconnect route may never have been
+// invoked for it. Errors are logged, as the state expires eventually.
+func (h *Handler) cleanup(ctx context.Context, d ack.Delivery, rec *metrics.Recorder) {
+	if h.closer == nil {
+		return
+	}
+
+	start := time.Now()
+	removed, err := h.closer.Cleanup(ctx, redis.Tenant(d.Tenant), d.ConnectionID)
+	rec.Since(metrics.RedisLatency, start)
+	if err != nil {
+		logger.FromContext(ctx).Error("failed to delete websocket connection from cache",
+			zap.String("receiver", d.ConnectionID),
+			zap.Error(err))
+		return
+	}
+
+	if removed {
+		rec.Increment(metrics.ConnectionsRemoved, 1)
+	}
+}
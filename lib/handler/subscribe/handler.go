@@ -0,0 +1,224 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package subscribe provides the handler invoked by Amazon API Gateway when a client subscribes to, or unsubscribes
+// from, a channel.
+package subscribe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw"
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw/ws"
+	"com.aws-samples/apigateway.websockets.golang/lib/channel"
+	"com.aws-samples/apigateway.websockets.golang/lib/filter"
+	"com.aws-samples/apigateway.websockets.golang/lib/handler"
+	"com.aws-samples/apigateway.websockets.golang/lib/logger"
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/policy"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"com.aws-samples/apigateway.websockets.golang/lib/tenant"
+	"com.aws-samples/apigateway.websockets.golang/lib/tracing"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Routes of the requests handled by the Handler.
+const (
+	RouteSubscribe   = "subscribe"
+	RouteUnsubscribe = "unsubscribe"
+)
+
+// errUnknownRoute is returned for requests of routes which are not handled by the Handler.
+var errUnknownRoute = errors.New("unknown subscription route")
+
+// Dependencies holds the subscriptions and the clients they are authorized with.
+type Dependencies struct {
+	Subscriptions *channel.Subscriptions
+
+	// Tenants resolves the tenant of the requesting connection, whose channels are the only ones it can subscribe to.
+	Tenants *tenant.Resolver
+
+	// Policy decides whether the connection may subscribe to the channel. A nil Engine allows every channel.
+	Policy *policy.Engine
+
+	// Config is the base or parent AWS configuration used to create the Amazon API Gateway Management API client.
+	Config aws.Config
+
+	// ManagementAPI is an optional, preconfigured Amazon API Gateway Management API client. When nil, the client is
+	// lazily created from Config upon the first invocation.
+	ManagementAPI *apigatewaymanagementapi.Client
+
+	// Metrics creates the recorder for the metrics of each invocation. A nil Emitter discards all metrics.
+	Metrics *metrics.Emitter
+}
+
+// Handler handles WebSocket subscribe and unsubscribe requests.
+type Handler struct {
+	subscriptions *channel.Subscriptions
+	tenants       *tenant.Resolver
+	policy        *policy.Engine
+	cfg           aws.Config
+	apiClient     *apigatewaymanagementapi.Client
+	metrics       *metrics.Emitter
+}
+
+// NewHandler creates a new Handler from the provided dependencies.
+func NewHandler(deps Dependencies) *Handler {
+	return &Handler{
+		subscriptions: deps.Subscriptions,
+		tenants:       deps.Tenants,
+		policy:        deps.Policy,
+		cfg:           deps.Config,
+		apiClient:     deps.ManagementAPI,
+		metrics:       deps.Metrics,
+	}
+}
+
+// Handle receives a synchronous invocation from API Gateway when a client subscribes to, or unsubscribes from, a
+// channel or a pattern of channels, see channel.Match. Subscriptions are subject to the channel policy, while a
+// connection may always unsubscribe. The client is sent a SubscriptionEnvelop with the outcome of the request.
+func (h *Handler) Handle(ctx context.Context, req *events.APIGatewayWebsocketProxyRequest) (res apigw.Response, err error) {
+	ctx = logger.ForRequest(ctx, req)
+	log := logger.FromContext(ctx)
+
+	ctx, span := tracing.Tracer().Start(ctx, "websocket "+req.RequestContext.RouteKey,
+		trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(tracing.RequestAttributes(req)...))
+
+	rec := h.metrics.Recorder()
+	rec.SetDimension(metrics.DimensionStage, req.RequestContext.Stage)
+
+	defer func() { handler.Finish(ctx, span, rec, err) }()
+
+	if h.apiClient == nil {
+		h.apiClient = apigw.NewAPIGatewayManagementClient(&h.cfg, req.RequestContext.DomainName, req.RequestContext.Stage)
+	}
+
+	input, err := new(ws.SubscribeEnvelop).Decode([]byte(req.Body))
+	if err == nil {
+		err = channel.ValidatePattern(input.Channel)
+	}
+
+	if err == nil && input.Message != RouteSubscribe && input.Message != RouteUnsubscribe {
+		err = errUnknownRoute
+	}
+
+	if err == nil && input.Filter != "" {
+		err = validateFilter(input)
+	}
+
+	if err != nil {
+		log.Error("failed to parse client subscription request", zap.Error(err))
+		return apigw.BadRequestResponse(), err
+	}
+
+	tenantID, res, err := handler.ResolveTenant(ctx, h.tenants, req, rec)
+	if err != nil {
+		return res, err
+	}
+
+	log = log.With(zap.String("tenant", tenantID), zap.String("channel", input.Channel))
+	ks := redis.Tenant(tenantID)
+	id := req.RequestContext.ConnectionID
+
+	reply := &ws.SubscriptionEnvelop{Channel: input.Channel}
+	res = apigw.OkResponse()
+	if input.Message == RouteUnsubscribe {
+		start := time.Now()
+		err = h.subscriptions.Unsubscribe(ctx, ks, input.Channel, id)
+		rec.Since(metrics.RedisLatency, start)
+		if err != nil {
+			log.Error("failed to delete subscription from cache", zap.Error(err))
+			return apigw.InternalServerErrorResponse(), err
+		}
+
+		log.Info("websocket connection unsubscribed")
+		reply.Message = "unsubscribed"
+		rec.Increment(metrics.SubscriptionsRemoved, 1)
+	} else {
+		start := time.Now()
+		allowed, err := h.policy.Authorize(ctx, ks, id, policy.Subscribe, input.Channel)
+		rec.Since(metrics.RedisLatency, start)
+		if err != nil {
+			log.Error("failed to read connection claims from cache", zap.Error(err))
+			return apigw.InternalServerErrorResponse(), err
+		}
+
+		switch {
+		case !allowed:
+			log.Info("deny subscription by channel policy")
+			reply.Message = "denied"
+			res = apigw.ForbiddenResponse()
+			rec.Increment(metrics.ChannelAccessDenied, 1)
+		case input.Channel == channel.Default:
+			// Every connection receives the messages of the default channel.
+			reply.Message = "subscribed"
+		default:
+			start = time.Now()
+			err = h.subscriptions.Subscribe(ctx, ks, input.Channel, id, input.Filter)
+			rec.Since(metrics.RedisLatency, start)
+			if err != nil {
+				log.Error("failed to cache subscription", zap.Error(err))
+				return apigw.InternalServerErrorResponse(), err
+			}
+
+			log.Info("websocket connection subscribed")
+			reply.Message = "subscribed"
+			rec.Increment(metrics.SubscriptionsAdded, 1)
+		}
+	}
+
+	data, err := reply.Encode()
+	if err != nil {
+		log.Error("failed to encode output", zap.Error(err))
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	timing, err := apigw.PostToConnection(ctx, h.apiClient, id, data)
+	timing.Record(rec)
+	if err != nil {
+		log.Error("failed to send subscription reply", zap.Error(err))
+		return apigw.InternalServerErrorResponse(), err
+	}
+
+	return res, nil
+}
+
+// validateFilter checks that the filter expression of the subscription can be evaluated.
+func validateFilter(input *ws.SubscribeEnvelop) error {
+	if input.Channel == channel.Default {
+		return errors.New("invalid filter: the default channel can not be filtered")
+	}
+
+	if len(input.Filter) > filter.MaxLength {
+		return fmt.Errorf("invalid filter: longer than %d characters", filter.MaxLength)
+	}
+
+	_, err := filter.Parse(input.Filter)
+	return err
+}
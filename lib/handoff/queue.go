@@ -0,0 +1,184 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package handoff passes the recipients of a message which could not be attempted before the deadline of an invocation
+// on to a later invocation through an Amazon SQS queue, so that no recipient is silently skipped.
+package handoff
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// EnvQueueURL is the environment variable holding the URL of the queue.
+const EnvQueueURL = "HANDOFF_QUEUE_URL"
+
+// maxBodySize is the maximum size of an Amazon SQS message body.
+const maxBodySize = 256 * 1024
+
+// ErrTooLarge is returned by Enqueue when the payload of the task leaves no room for recipients in a queue message.
+var ErrTooLarge = errors.New("payload too large to hand off")
+
+// Task is the remaining work of a message. It holds everything needed to send the message to the remaining
+// recipients without the original request.
+type Task struct {
+	Tenant    string `json:"tenant"`
+	MessageID string `json:"messageId"`
+	Ack       bool   `json:"ack,omitempty"`
+
+	// Domain and Stage locate the API the recipients are connected to.
+	Domain string `json:"domain"`
+	Stage  string `json:"stage"`
+
+	// Trace holds the W3C trace context of the invocation which handed off the task.
+	Trace map[string]string `json:"trace,omitempty"`
+
+	Payload    json.RawMessage `json:"payload"`
+	Recipients []string        `json:"recipients"`
+
+	// Filters holds the filter expressions of the recipients whose subscriptions are filtered, by connection ID.
+	Filters map[string]string `json:"filters,omitempty"`
+
+	// Exclude holds the IDs of the connections which are skipped. When Only is not empty, the recipients which it does
+	// not hold are skipped as well.
+	Exclude []string `json:"exclude,omitempty"`
+	Only    []string `json:"only,omitempty"`
+
+	// ExpiresAt is the time at which the message expires in Unix milliseconds, or zero if it does not expire.
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+}
+
+// Expired reports whether the message of the task expired at the provided time.
+func (t Task) Expired(now time.Time) bool {
+	return t.ExpiresAt > 0 && now.UnixMilli() >= t.ExpiresAt
+}
+
+// Queue hands off tasks through an Amazon SQS queue.
+type Queue struct {
+	client *sqs.Client
+	url    string
+}
+
+// NewQueue creates a new Queue sending to the queue with the provided URL.
+func NewQueue(client *sqs.Client, url string) *Queue {
+	return &Queue{client: client, url: url}
+}
+
+// Enqueue sends the task to the queue. Tasks which exceed the maximum size of a queue message are split into several
+// tasks, each holding a part of the recipients.
+func (q *Queue) Enqueue(ctx context.Context, task Task) error {
+	empty := task
+	empty.Recipients = nil
+	empty.Filters = nil
+	base, err := json.Marshal(empty)
+	if err != nil {
+		return err
+	}
+
+	if len(base) >= maxBodySize {
+		return ErrTooLarge
+	}
+
+	// Each recipient adds its quoted ID and a separator to the encoded task, and a filtered recipient additionally its
+	// quoted ID, quoted filter and separators to the encoded filters.
+	remaining := task.Recipients
+	for len(remaining) > 0 {
+		size, n := len(base)+len(`,"filters":{}`), 0
+		for n < len(remaining) {
+			next := len(remaining[n]) + 3
+			if expr, ok := task.Filters[remaining[n]]; ok {
+				encoded, _ := json.Marshal(expr)
+				next += len(remaining[n]) + len(encoded) + 4
+			}
+
+			if size+next >= maxBodySize {
+				break
+			}
+
+			size += next
+			n++
+		}
+
+		if n == 0 {
+			return ErrTooLarge
+		}
+
+		part := task
+		part.Recipients = remaining[:n]
+		part.Filters = nil
+		for _, id := range part.Recipients {
+			if expr, ok := task.Filters[id]; ok {
+				if part.Filters == nil {
+					part.Filters = make(map[string]string)
+				}
+
+				part.Filters[id] = expr
+			}
+		}
+
+		if err := q.send(ctx, part); err != nil {
+			return err
+		}
+
+		remaining = remaining[n:]
+	}
+
+	return nil
+}
+
+// send sends a single task to the queue.
+func (q *Queue) send(ctx context.Context, task Task) error {
+	body, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.client.SendMessageRequest(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.url),
+		MessageBody: aws.String(string(body)),
+	}).Send(ctx)
+	return err
+}
+
+// Decode decodes the task from the body of a queue message.
+func Decode(body string) (Task, error) {
+	var task Task
+	err := json.Unmarshal([]byte(body), &task)
+	return task, err
+}
+
+// BatchResponse is the response of a function invoked with a batch of queue messages. Only the messages reported as
+// failed are received again, the other messages of the batch are deleted from the queue. It requires the event source
+// mapping of the function to enable the ReportBatchItemFailures response type.
+type BatchResponse struct {
+	BatchItemFailures []BatchItemFailure `json:"batchItemFailures"`
+}
+
+// BatchItemFailure identifies a failed queue message by its ID.
+type BatchItemFailure struct {
+	ItemIdentifier string `json:"itemIdentifier"`
+}
@@ -0,0 +1,226 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package handoff
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/defaults"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// queueServer emulates the SendMessage action of Amazon SQS and records the bodies of the messages sent to it.
+type queueServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	bodies []string
+}
+
+func newQueueServer(t *testing.T) *queueServer {
+	s := &queueServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("Action") != "SendMessage" {
+			http.Error(w, "unsupported request", http.StatusBadRequest)
+			return
+		}
+
+		body := r.PostForm.Get("MessageBody")
+		s.mu.Lock()
+		s.bodies = append(s.bodies, body)
+		s.mu.Unlock()
+
+		sum := md5.Sum([]byte(body))
+		fmt.Fprintf(w, "<SendMessageResponse><SendMessageResult><MessageId>%d</MessageId>"+
+			"<MD5OfMessageBody>%s</MD5OfMessageBody></SendMessageResult></SendMessageResponse>",
+			len(s.bodies), hex.EncodeToString(sum[:]))
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// queue returns a Queue sending to the server.
+func (s *queueServer) queue() *Queue {
+	cfg := defaults.Config()
+	cfg.Region = "us-east-1"
+	cfg.Credentials = aws.NewStaticCredentialsProvider("AKID", "SECRET", "")
+	cfg.EndpointResolver = aws.ResolveWithEndpointURL(s.URL)
+	return NewQueue(sqs.New(cfg), s.URL+"/123456789012/handoff")
+}
+
+// tasks decodes the messages sent to the server.
+func (s *queueServer) tasks(t *testing.T) []Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := make([]Task, len(s.bodies))
+	for i, body := range s.bodies {
+		if len(body) >= maxBodySize {
+			t.Errorf("message %d has %d bytes, want less than %d", i, len(body), maxBodySize)
+		}
+
+		task, err := Decode(body)
+		if err != nil {
+			t.Fatalf("Decode returned error: %v", err)
+		}
+
+		tasks[i] = task
+	}
+
+	return tasks
+}
+
+func TestEnqueue(t *testing.T) {
+	s := newQueueServer(t)
+	task := Task{
+		Tenant:     "acme",
+		MessageID:  "m1",
+		Domain:     "example.execute-api.us-east-1.amazonaws.com",
+		Stage:      "prod",
+		Payload:    json.RawMessage(`{"hello":"world"}`),
+		Recipients: []string{"conn1", "conn2"},
+		Filters:    map[string]string{"conn2": `type == "alert"`},
+		ExpiresAt:  time.Now().Add(time.Minute).UnixMilli(),
+	}
+
+	if err := s.queue().Enqueue(context.Background(), task); err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+
+	if got := s.tasks(t); len(got) != 1 || !reflect.DeepEqual(got[0], task) {
+		t.Errorf("Enqueue sent %+v, want %+v", got, task)
+	}
+}
+
+func TestEnqueueSplit(t *testing.T) {
+	s := newQueueServer(t)
+	task := Task{
+		Tenant:    "acme",
+		MessageID: "m1",
+		Payload:   json.RawMessage(`"` + strings.Repeat("x", 100*1024) + `"`),
+		Filters:   make(map[string]string),
+	}
+
+	for i := 0; i < 10000; i++ {
+		id := "connection-" + strconv.Itoa(i)
+		task.Recipients = append(task.Recipients, id)
+		if i%3 == 0 {
+			task.Filters[id] = `region == "eu"`
+		}
+	}
+
+	if err := s.queue().Enqueue(context.Background(), task); err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+
+	tasks := s.tasks(t)
+	if len(tasks) < 2 {
+		t.Fatalf("Enqueue sent %d messages, want the task split", len(tasks))
+	}
+
+	var recipients []string
+	for _, part := range tasks {
+		if part.MessageID != task.MessageID || string(part.Payload) != string(task.Payload) {
+			t.Errorf("part of the task is %s with a payload of %d bytes", part.MessageID, len(part.Payload))
+		}
+
+		for _, id := range part.Recipients {
+			if part.Filters[id] != task.Filters[id] {
+				t.Errorf("filter of %s is %q, want %q", id, part.Filters[id], task.Filters[id])
+			}
+		}
+
+		if len(part.Filters) > len(part.Recipients) {
+			t.Errorf("part holds %d filters for %d recipients", len(part.Filters), len(part.Recipients))
+		}
+
+		recipients = append(recipients, part.Recipients...)
+	}
+
+	if !reflect.DeepEqual(recipients, task.Recipients) {
+		t.Errorf("parts hold %d recipients, want all %d in order", len(recipients), len(task.Recipients))
+	}
+}
+
+func TestEnqueueTooLarge(t *testing.T) {
+	s := newQueueServer(t)
+	tests := []struct {
+		name    string
+		payload int
+	}{
+		{"payload", maxBodySize},
+		{"recipient", maxBodySize - 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := Task{
+				Tenant:     "acme",
+				MessageID:  "m1",
+				Payload:    json.RawMessage(`"` + strings.Repeat("x", tt.payload) + `"`),
+				Recipients: []string{strings.Repeat("c", 256)},
+			}
+
+			if err := s.queue().Enqueue(context.Background(), task); err != ErrTooLarge {
+				t.Errorf("Enqueue returned %v, want %v", err, ErrTooLarge)
+			}
+		})
+	}
+
+	if got := s.tasks(t); len(got) != 0 {
+		t.Errorf("Enqueue sent %d messages, want none", len(got))
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		expiresAt int64
+		want      bool
+	}{
+		{0, false},
+		{now.Add(time.Second).UnixMilli(), false},
+		{now.UnixMilli(), true},
+		{now.Add(-time.Second).UnixMilli(), true},
+	}
+
+	for _, tt := range tests {
+		if got := (Task{ExpiresAt: tt.expiresAt}).Expired(now); got != tt.want {
+			t.Errorf("Expired with ExpiresAt %d returned %v, want %v", tt.expiresAt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package logger
+
+import (
+	"context"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"go.uber.org/zap"
+)
+
+type contextKey struct{}
+
+// loggers holds the request-scoped logger and its sampled counterpart.
+type loggers struct {
+	log     *zap.Logger
+	sampled *zap.Logger
+}
+
+// ForRequest returns a copy of the context holding a logger with the request's ID, connection ID, route key and stage,
+// as well as the AWS Lambda request ID, pre-bound.
+func ForRequest(ctx context.Context, req *events.APIGatewayWebsocketProxyRequest) context.Context {
+	fields := []zap.Field{
+		zap.String("requestId", req.RequestContext.RequestID),
+		zap.String("connectionId", req.RequestContext.ConnectionID),
+		zap.String("routeKey", req.RequestContext.RouteKey),
+		zap.String("stage", req.RequestContext.Stage),
+	}
+
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		fields = append(fields, zap.String("lambdaRequestId", lc.AwsRequestID))
+	}
+
+	return With(ctx, fields...)
+}
+
+// With returns a copy of the context holding the context's logger with the provided fields added.
+func With(ctx context.Context, fields ...zap.Field) context.Context {
+	l := fromContext(ctx)
+	return context.WithValue(ctx, contextKey{}, loggers{
+		log:     l.log.With(fields...),
+		sampled: l.sampled.With(fields...),
+	})
+}
+
+// FromContext returns the logger held by the context, or Instance if the context holds none.
+func FromContext(ctx context.Context) *zap.Logger {
+	return fromContext(ctx).log
+}
+
+// Sampled returns the sampled logger held by the context. The sampled logger drops repeated entries with the same level
+// and message, which makes it suitable for high-volume entries such as those logged for each recipient of a message.
+// The sampling state is shared by all sampled loggers of the execution context.
+func Sampled(ctx context.Context) *zap.Logger {
+	return fromContext(ctx).sampled
+}
+
+func fromContext(ctx context.Context) loggers {
+	if l, ok := ctx.Value(contextKey{}).(loggers); ok {
+		return l
+	}
+
+	return loggers{log: Instance, sampled: sampled}
+}
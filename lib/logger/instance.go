@@ -22,15 +22,119 @@
 
 // Package logger provides a singleton logging instance which is used across the same AWS Lambda execution contexts.
 // Reusing the client across execution contexts provides some performance enhancements and ensures the logger
-// configuration is consistent across all handlers.
+// configuration is consistent across all handlers. Handlers attach a request-scoped logger to the invocation's context
+// with ForRequest and retrieve it with FromContext.
 package logger
 
-import "go.uber.org/zap"
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Environment variables read by ConfigFromEnv.
+const (
+	EnvLevel            = "LOG_LEVEL"
+	EnvFormat           = "LOG_FORMAT"
+	EnvSampleInitial    = "LOG_SAMPLING_INITIAL"
+	EnvSampleThereafter = "LOG_SAMPLING_THEREAFTER"
+)
 
 // Instance used across Lambda invocations for this execution context.
 var Instance *zap.Logger
 
-// initialize the logger used across Lambda invocations for the same execution context.
+// sampled is derived from Instance and samples repeated entries. See Sampled.
+var sampled *zap.Logger
+
+// Config configures the logger created by New.
+type Config struct {
+	// Level is the minimum enabled logging level.
+	Level zapcore.Level
+
+	// Format is the encoding of the log entries, either json or console.
+	Format string
+
+	// SampleInitial and SampleThereafter configure the sampled logger. Every second, the first SampleInitial entries
+	// with the same level and message are logged, and every SampleThereafter entry after that.
+	SampleInitial    int
+	SampleThereafter int
+}
+
+// DefaultConfig returns the configuration used when no environment variables are set.
+func DefaultConfig() Config {
+	return Config{
+		Level:            zapcore.InfoLevel,
+		Format:           "json",
+		SampleInitial:    10,
+		SampleThereafter: 100,
+	}
+}
+
+// ConfigFromEnv returns DefaultConfig overridden by any of the LOG_* environment variables which are set.
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	if v := os.Getenv(EnvLevel); v != "" {
+		if err := cfg.Level.UnmarshalText([]byte(v)); err != nil {
+			return cfg, fmt.Errorf("invalid %s %q: %w", EnvLevel, v, err)
+		}
+	}
+
+	if v := os.Getenv(EnvFormat); v != "" {
+		if v != "json" && v != "console" {
+			return cfg, fmt.Errorf("invalid %s %q: must be json or console", EnvFormat, v)
+		}
+
+		cfg.Format = v
+	}
+
+	for name, n := range map[string]*int{
+		EnvSampleInitial:    &cfg.SampleInitial,
+		EnvSampleThereafter: &cfg.SampleThereafter,
+	} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed < 1 {
+				return cfg, fmt.Errorf("invalid %s %q: must be a positive integer", name, v)
+			}
+
+			*n = parsed
+		}
+	}
+
+	return cfg, nil
+}
+
+// New creates a new production logger from the provided configuration.
+func New(cfg Config) (*zap.Logger, error) {
+	zc := zap.NewProductionConfig()
+	zc.Level = zap.NewAtomicLevelAt(cfg.Level)
+	zc.Encoding = cfg.Format
+	if cfg.Format == "console" {
+		zc.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+	}
+
+	return zc.Build()
+}
+
+// initialize the logger used across Lambda invocations for the same execution context. An invalid configuration falls
+// back to the default configuration, and the configuration error is logged.
 func init() {
-	Instance, _ = zap.NewProduction()
+	cfg, err := ConfigFromEnv()
+	if err == nil {
+		Instance, err = New(cfg)
+	}
+
+	if err != nil {
+		cfg = DefaultConfig()
+		Instance, _ = New(cfg)
+		Instance.Error("invalid logger configuration, using defaults", zap.Error(err))
+	}
+
+	sampled = Instance.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewSampler(core, time.Second, cfg.SampleInitial, cfg.SampleThereafter)
+	}))
 }
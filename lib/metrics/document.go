@@ -0,0 +1,126 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package metrics
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Unit is the CloudWatch unit of a metric.
+type Unit string
+
+// Units used by the application's metrics.
+const (
+	Count          Unit = "Count"
+	CountPerSecond Unit = "Count/Second"
+	Milliseconds   Unit = "Milliseconds"
+	None           Unit = "None"
+)
+
+// maxValues is the maximum number of values a single metric may hold in an embedded metric format document.
+const maxValues = 100
+
+// Metric is a named metric holding one or more values. CloudWatch computes statistics, including percentiles, across
+// all values of a metric.
+type Metric struct {
+	Name   string
+	Unit   Unit
+	Values []float64
+}
+
+// Document is a single CloudWatch embedded metric format document. Dimensions holds the dimension names and values, all
+// of which form a single dimension set. Properties are included in the document without being turned into metrics,
+// which makes them searchable with CloudWatch Logs Insights.
+type Document struct {
+	Timestamp  time.Time
+	Namespace  string
+	Dimensions map[string]string
+	Metrics    []Metric
+	Properties map[string]interface{}
+}
+
+// Value returns the values of the named metric and whether the metric is present in the document.
+func (d Document) Value(name string) ([]float64, bool) {
+	for _, m := range d.Metrics {
+		if m.Name == name {
+			return m.Values, true
+		}
+	}
+
+	return nil, false
+}
+
+// MarshalJSON encodes the document in the CloudWatch embedded metric format. A metric with a single value is encoded as
+// a number, a metric with multiple values as an array of numbers.
+//
+// See https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+func (d Document) MarshalJSON() ([]byte, error) {
+	type definition struct {
+		Name string `json:"Name"`
+		Unit Unit   `json:"Unit,omitempty"`
+	}
+
+	type directive struct {
+		Namespace  string       `json:"Namespace"`
+		Dimensions [][]string   `json:"Dimensions"`
+		Metrics    []definition `json:"Metrics"`
+	}
+
+	type metadata struct {
+		Timestamp         int64       `json:"Timestamp"`
+		CloudWatchMetrics []directive `json:"CloudWatchMetrics"`
+	}
+
+	root := make(map[string]interface{}, len(d.Properties)+len(d.Dimensions)+len(d.Metrics)+1)
+	for k, v := range d.Properties {
+		root[k] = v
+	}
+
+	dimensions := make([]string, 0, len(d.Dimensions))
+	for k, v := range d.Dimensions {
+		dimensions = append(dimensions, k)
+		root[k] = v
+	}
+
+	definitions := make([]definition, 0, len(d.Metrics))
+	for _, m := range d.Metrics {
+		definitions = append(definitions, definition{Name: m.Name, Unit: m.Unit})
+		if len(m.Values) == 1 {
+			root[m.Name] = m.Values[0]
+		} else {
+			root[m.Name] = m.Values
+		}
+	}
+
+	root["_aws"] = metadata{
+		Timestamp: d.Timestamp.UnixNano() / int64(time.Millisecond),
+		CloudWatchMetrics: []directive{{
+			Namespace:  d.Namespace,
+			Dimensions: [][]string{dimensions},
+			Metrics:    definitions,
+		}},
+	}
+
+	return json.Marshal(root)
+}
@@ -0,0 +1,256 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package metrics provides CloudWatch metrics in the embedded metric format (EMF). A Recorder collects the metrics of a
+// single AWS Lambda invocation and emits them as EMF documents to a Sink when flushed. Writing the documents to the
+// function's log stream has CloudWatch extract the metrics without calling the CloudWatch API.
+package metrics
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultNamespace is the CloudWatch namespace used when METRICS_NAMESPACE is not set.
+const DefaultNamespace = "ApiGatewayWebSockets"
+
+// EnvNamespace is the environment variable read by NamespaceFromEnv.
+const EnvNamespace = "METRICS_NAMESPACE"
+
+// Names of the metrics emitted by the handlers.
+const (
+	ConnectionsAdded      = "ConnectionsAdded"
+	ConnectionsRemoved    = "ConnectionsRemoved"
+	ConnectionsRejected   = "ConnectionsRejected"
+	ConnectionsEvicted    = "ConnectionsEvicted"
+	ConnectionsClosed     = "ConnectionsClosed"
+	ChannelAccessDenied   = "ChannelAccessDenied"
+	SubscriptionsAdded    = "SubscriptionsAdded"
+	SubscriptionsRemoved  = "SubscriptionsRemoved"
+	DuplicatesSkipped     = "DuplicatesSkipped"
+	FanOutSize            = "FanOutSize"
+	FanOutWorkers         = "FanOutWorkers"
+	FanOutThroughput      = "FanOutThroughput"
+	FanOutThrottled       = "FanOutThrottled"
+	DeliveriesSucceeded   = "DeliveriesSucceeded"
+	DeliveriesFiltered    = "DeliveriesFiltered"
+	DeliveriesExcluded    = "DeliveriesExcluded"
+	DeliveriesExpired     = "DeliveriesExpired"
+	MessagesExpired       = "MessagesExpired"
+	MessagesScheduled     = "MessagesScheduled"
+	MessagesCanceled      = "MessagesCanceled"
+	MessagesDispatched    = "MessagesDispatched"
+	DispatchDelay         = "DispatchDelay"
+	DeliveriesGone        = "DeliveriesGone"
+	DeliveriesFailed      = "DeliveriesFailed"
+	DeliveriesRetried     = "DeliveriesRetried"
+	DeliveryAlerts        = "DeliveryAlerts"
+	DeliveriesHandedOff   = "DeliveriesHandedOff"
+	DeliveriesDropped     = "DeliveriesDropped"
+	PublishLatency        = "PublishLatency"
+	RedisLatency          = "RedisLatency"
+	HandoffLatency        = "HandoffLatency"
+	TimeToFirstByte       = "TimeToFirstByte"
+	ConnectLatency        = "ConnectLatency"
+	TLSHandshakeLatency   = "TLSHandshakeLatency"
+	HTTPConnectionsOpened = "HTTPConnectionsOpened"
+	HTTPConnectionsReused = "HTTPConnectionsReused"
+	AcksReceived          = "AcksReceived"
+	Redeliveries          = "Redeliveries"
+	DeadLettered          = "DeadLettered"
+	MessagesFetched       = "MessagesFetched"
+	MessagesMissing       = "MessagesMissing"
+)
+
+// Names of the dimensions set by the handlers.
+const (
+	DimensionStage       = "Stage"
+	DimensionMessageType = "MessageType"
+)
+
+// NamespaceFromEnv returns the namespace from the METRICS_NAMESPACE environment variable, or DefaultNamespace.
+func NamespaceFromEnv() string {
+	if ns := os.Getenv(EnvNamespace); ns != "" {
+		return ns
+	}
+
+	return DefaultNamespace
+}
+
+// Emitter creates Recorders which emit to the same namespace and sink. An Emitter is typically created once per AWS
+// Lambda execution context, while a Recorder is created for each invocation.
+type Emitter struct {
+	namespace string
+	sink      Sink
+}
+
+// NewEmitter creates a new Emitter. A nil sink discards all documents.
+func NewEmitter(namespace string, sink Sink) *Emitter {
+	if sink == nil {
+		sink = Discard
+	}
+
+	return &Emitter{namespace: namespace, sink: sink}
+}
+
+// Recorder creates a new Recorder. Calling Recorder on a nil Emitter returns a Recorder which discards its metrics.
+func (e *Emitter) Recorder() *Recorder {
+	if e == nil {
+		return NewRecorder(DefaultNamespace, Discard)
+	}
+
+	return NewRecorder(e.namespace, e.sink)
+}
+
+// Recorder collects metrics, dimensions and properties and emits them as a document when flushed. A Recorder is safe
+// for concurrent use.
+type Recorder struct {
+	mu         sync.Mutex
+	namespace  string
+	sink       Sink
+	dimensions map[string]string
+	properties map[string]interface{}
+	metrics    []*Metric
+	index      map[string]*Metric
+}
+
+// NewRecorder creates a new Recorder emitting to the provided namespace and sink.
+func NewRecorder(namespace string, sink Sink) *Recorder {
+	return &Recorder{
+		namespace:  namespace,
+		sink:       sink,
+		dimensions: make(map[string]string),
+		properties: make(map[string]interface{}),
+		index:      make(map[string]*Metric),
+	}
+}
+
+// SetDimension sets the value of the named dimension. All metrics of the Recorder share the same dimensions.
+func (r *Recorder) SetDimension(name, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.dimensions[name] = value
+}
+
+// SetProperty sets a property which is included in the emitted documents without becoming a metric.
+func (r *Recorder) SetProperty(name string, value interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.properties[name] = value
+}
+
+// Increment adds delta to the named counter. A counter holds a single value.
+func (r *Recorder) Increment(name string, delta float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := r.metric(name, Count)
+	if len(m.Values) == 0 {
+		m.Values = append(m.Values, 0)
+	}
+
+	m.Values[0] += delta
+}
+
+// Observe records a value of the named metric. Every observed value is kept, which allows CloudWatch to compute
+// percentiles across them.
+func (r *Recorder) Observe(name string, value float64, unit Unit) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := r.metric(name, unit)
+	m.Values = append(m.Values, value)
+}
+
+// Duration records the provided duration of the named metric in milliseconds.
+func (r *Recorder) Duration(name string, d time.Duration) {
+	r.Observe(name, float64(d)/float64(time.Millisecond), Milliseconds)
+}
+
+// Since records the time elapsed since start of the named metric in milliseconds.
+func (r *Recorder) Since(name string, start time.Time) {
+	r.Duration(name, time.Since(start))
+}
+
+// Flush emits the collected metrics and resets them. The dimensions and properties are kept. Metrics with more values
+// than a single document can hold are split across multiple documents. Nothing is emitted when no metrics were
+// collected.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for len(r.metrics) > 0 {
+		doc := Document{
+			Timestamp:  now,
+			Namespace:  r.namespace,
+			Dimensions: make(map[string]string, len(r.dimensions)),
+			Properties: make(map[string]interface{}, len(r.properties)),
+		}
+
+		for k, v := range r.dimensions {
+			doc.Dimensions[k] = v
+		}
+
+		for k, v := range r.properties {
+			doc.Properties[k] = v
+		}
+
+		var remaining []*Metric
+		for _, m := range r.metrics {
+			n := len(m.Values)
+			if n > maxValues {
+				n = maxValues
+			}
+
+			doc.Metrics = append(doc.Metrics, Metric{Name: m.Name, Unit: m.Unit, Values: m.Values[:n]})
+			if n < len(m.Values) {
+				remaining = append(remaining, &Metric{Name: m.Name, Unit: m.Unit, Values: m.Values[n:]})
+			}
+		}
+
+		r.metrics = remaining
+		if err := r.sink.Emit(doc); err != nil {
+			r.metrics = nil
+			r.index = make(map[string]*Metric)
+			return err
+		}
+	}
+
+	r.index = make(map[string]*Metric)
+	return nil
+}
+
+// metric returns the named metric, creating it if it does not exist. The caller must hold the lock.
+func (r *Recorder) metric(name string, unit Unit) *Metric {
+	m, ok := r.index[name]
+	if !ok {
+		m = &Metric{Name: name, Unit: unit}
+		r.index[name] = m
+		r.metrics = append(r.metrics, m)
+	}
+
+	return m
+}
@@ -0,0 +1,235 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecorderFlush(t *testing.T) {
+	sink := &MemorySink{}
+	rec := NewEmitter("Test", sink).Recorder()
+	rec.SetDimension(DimensionStage, "dev")
+	rec.SetProperty("requestId", "42")
+	rec.Increment(ConnectionsAdded, 1)
+	rec.Increment(ConnectionsAdded, 2)
+	rec.Observe(FanOutSize, 3, Count)
+	rec.Observe(FanOutSize, 5, Count)
+	rec.Duration(RedisLatency, 1500*time.Microsecond)
+
+	if err := rec.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	docs := sink.Documents()
+	if len(docs) != 1 {
+		t.Fatalf("got %d documents, want 1", len(docs))
+	}
+
+	doc := docs[0]
+	if doc.Namespace != "Test" {
+		t.Errorf("got namespace %q, want %q", doc.Namespace, "Test")
+	}
+
+	if want := map[string]string{DimensionStage: "dev"}; !reflect.DeepEqual(doc.Dimensions, want) {
+		t.Errorf("got dimensions %v, want %v", doc.Dimensions, want)
+	}
+
+	if want := map[string]interface{}{"requestId": "42"}; !reflect.DeepEqual(doc.Properties, want) {
+		t.Errorf("got properties %v, want %v", doc.Properties, want)
+	}
+
+	want := []Metric{
+		{Name: ConnectionsAdded, Unit: Count, Values: []float64{3}},
+		{Name: FanOutSize, Unit: Count, Values: []float64{3, 5}},
+		{Name: RedisLatency, Unit: Milliseconds, Values: []float64{1.5}},
+	}
+
+	if !reflect.DeepEqual(doc.Metrics, want) {
+		t.Errorf("got metrics %v, want %v", doc.Metrics, want)
+	}
+}
+
+func TestRecorderFlushResets(t *testing.T) {
+	sink := &MemorySink{}
+	rec := NewRecorder("Test", sink)
+	if err := rec.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	if docs := sink.Documents(); len(docs) != 0 {
+		t.Fatalf("got %d documents without metrics, want none", len(docs))
+	}
+
+	rec.SetDimension(DimensionStage, "dev")
+	rec.Increment(ConnectionsAdded, 1)
+	_ = rec.Flush()
+	rec.Increment(ConnectionsAdded, 1)
+	_ = rec.Flush()
+
+	docs := sink.Documents()
+	if len(docs) != 2 {
+		t.Fatalf("got %d documents, want 2", len(docs))
+	}
+
+	for i, doc := range docs {
+		if v, _ := doc.Value(ConnectionsAdded); !reflect.DeepEqual(v, []float64{1}) {
+			t.Errorf("document %d: got %s %v, want [1]", i, ConnectionsAdded, v)
+		}
+
+		if doc.Dimensions[DimensionStage] != "dev" {
+			t.Errorf("document %d: dimension %s not kept across flushes", i, DimensionStage)
+		}
+	}
+}
+
+func TestRecorderFlushSplitsValues(t *testing.T) {
+	sink := &MemorySink{}
+	rec := NewRecorder("Test", sink)
+	rec.Increment(DeliveriesSucceeded, 1)
+	for i := 0; i < 2*maxValues+50; i++ {
+		rec.Observe(PublishLatency, float64(i), Milliseconds)
+	}
+
+	if err := rec.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	docs := sink.Documents()
+	if len(docs) != 3 {
+		t.Fatalf("got %d documents, want 3", len(docs))
+	}
+
+	for i, want := range []int{maxValues, maxValues, 50} {
+		v, ok := docs[i].Value(PublishLatency)
+		if !ok || len(v) != want {
+			t.Errorf("document %d: got %d values of %s, want %d", i, len(v), PublishLatency, want)
+		}
+
+		if _, ok := docs[i].Value(DeliveriesSucceeded); ok != (i == 0) {
+			t.Errorf("document %d: %s present = %t, want %t", i, DeliveriesSucceeded, ok, i == 0)
+		}
+	}
+
+	values := sink.Values(PublishLatency)
+	for i, v := range values {
+		if v != float64(i) {
+			t.Fatalf("value %d of %s is %v, values were reordered or lost", i, PublishLatency, v)
+		}
+	}
+
+	if len(values) != 2*maxValues+50 {
+		t.Errorf("got %d values of %s, want %d", len(values), PublishLatency, 2*maxValues+50)
+	}
+
+	if got := sink.Sum(DeliveriesSucceeded); got != 1 {
+		t.Errorf("got %s sum %v, want 1", DeliveriesSucceeded, got)
+	}
+}
+
+type failingSink struct{ calls int }
+
+func (s *failingSink) Emit(Document) error {
+	s.calls++
+	return errors.New("emit failed")
+}
+
+func TestRecorderFlushError(t *testing.T) {
+	sink := &failingSink{}
+	rec := NewRecorder("Test", sink)
+	for i := 0; i < 2*maxValues; i++ {
+		rec.Observe(PublishLatency, 1, Milliseconds)
+	}
+
+	if err := rec.Flush(); err == nil {
+		t.Fatal("Flush succeeded, want error")
+	}
+
+	if err := rec.Flush(); err != nil || sink.calls != 1 {
+		t.Errorf("got error %v after %d calls, want the metrics to be dropped after the first error", err, sink.calls)
+	}
+}
+
+func TestNilEmitter(t *testing.T) {
+	var e *Emitter
+	rec := e.Recorder()
+	rec.Increment(ConnectionsAdded, 1)
+	if err := rec.Flush(); err != nil {
+		t.Errorf("Flush failed: %v", err)
+	}
+}
+
+func TestDocumentMarshalJSON(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewWriterSink(&buf)
+	err := sink.Emit(Document{
+		Timestamp:  time.Unix(1600000000, 0),
+		Namespace:  "Test",
+		Dimensions: map[string]string{DimensionStage: "dev"},
+		Metrics: []Metric{
+			{Name: ConnectionsAdded, Unit: Count, Values: []float64{1}},
+			{Name: RedisLatency, Unit: Milliseconds, Values: []float64{1, 2}},
+		},
+		Properties: map[string]interface{}{"requestId": "42"},
+	})
+
+	if err != nil {
+		t.Fatalf("Emit failed: %v", err)
+	}
+
+	if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		t.Error("document is not terminated by a new line")
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("document is not valid JSON: %v", err)
+	}
+
+	want := map[string]interface{}{
+		"Stage":            "dev",
+		"requestId":        "42",
+		"ConnectionsAdded": 1.0,
+		"RedisLatency":     []interface{}{1.0, 2.0},
+		"_aws": map[string]interface{}{
+			"Timestamp": 1600000000000.0,
+			"CloudWatchMetrics": []interface{}{map[string]interface{}{
+				"Namespace":  "Test",
+				"Dimensions": []interface{}{[]interface{}{"Stage"}},
+				"Metrics": []interface{}{
+					map[string]interface{}{"Name": "ConnectionsAdded", "Unit": "Count"},
+					map[string]interface{}{"Name": "RedisLatency", "Unit": "Milliseconds"},
+				},
+			}},
+		},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got document %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,121 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// Sink receives the documents emitted by a Recorder.
+type Sink interface {
+	Emit(doc Document) error
+}
+
+// Discard is a Sink which drops all documents.
+var Discard Sink = discard{}
+
+type discard struct{}
+
+func (discard) Emit(Document) error { return nil }
+
+// WriterSink writes each document as a line of JSON to the underlying writer. In AWS Lambda, documents written to
+// standard output are picked up by CloudWatch Logs, which extracts the metrics.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink creates a new WriterSink writing to the provided writer.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// Emit writes the document to the underlying writer.
+func (s *WriterSink) Emit(doc Document) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+// MemorySink keeps the emitted documents in memory. It allows the metrics emitted by a handler to be inspected when
+// running locally or in tests.
+type MemorySink struct {
+	mu   sync.Mutex
+	docs []Document
+}
+
+// Emit stores the document.
+func (s *MemorySink) Emit(doc Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.docs = append(s.docs, doc)
+	return nil
+}
+
+// Documents returns the documents emitted so far.
+func (s *MemorySink) Documents() []Document {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Document(nil), s.docs...)
+}
+
+// Values returns all values of the named metric across the emitted documents.
+func (s *MemorySink) Values(name string) []float64 {
+	var values []float64
+	for _, doc := range s.Documents() {
+		if v, ok := doc.Value(name); ok {
+			values = append(values, v...)
+		}
+	}
+
+	return values
+}
+
+// Sum returns the sum of all values of the named metric across the emitted documents.
+func (s *MemorySink) Sum(name string) float64 {
+	var sum float64
+	for _, v := range s.Values(name) {
+		sum += v
+	}
+
+	return sum
+}
+
+// Reset drops the documents emitted so far.
+func (s *MemorySink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.docs = nil
+}
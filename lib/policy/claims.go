@@ -0,0 +1,92 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package policy
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"github.com/aws/aws-lambda-go/events"
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// claimsTTL is how long the claims of a connection are kept. Amazon API Gateway closes connections after 2 hours.
+const claimsTTL = 3 * time.Hour
+
+// ClaimStore keeps the claims of the API's Lambda authorizer for each connection in a hash. Only claims with string,
+// number or boolean values are kept, as strings.
+type ClaimStore struct {
+	redis redis.Client
+}
+
+// NewClaimStore creates a new ClaimStore.
+func NewClaimStore(client redis.Client) *ClaimStore {
+	return &ClaimStore{redis: client}
+}
+
+// Save stores the claims of the authorizer context of the connect request, if any.
+func (s *ClaimStore) Save(ctx context.Context, ks redis.Keyspace, req *events.APIGatewayWebsocketProxyRequest) error {
+	claims, ok := req.RequestContext.Authorizer.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	args := []string{ks.ConnectionKey(req.RequestContext.ConnectionID, "claims")}
+	for k, v := range claims {
+		switch v := v.(type) {
+		case string:
+			args = append(args, k, v)
+		case bool:
+			args = append(args, k, strconv.FormatBool(v))
+		case float64:
+			args = append(args, k, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+
+	if len(args) == 1 {
+		return nil
+	}
+
+	if err := redis.Do(ctx, s.redis, "HSET", radix.Cmd(nil, "HSET", args...)); err != nil {
+		return err
+	}
+
+	ttl := strconv.FormatInt(claimsTTL.Milliseconds(), 10)
+	return redis.Do(ctx, s.redis, "PEXPIRE", radix.Cmd(nil, "PEXPIRE", args[0], ttl))
+}
+
+// Load returns the claims stored for the connection. Connections without claims have an empty map.
+func (s *ClaimStore) Load(ctx context.Context, ks redis.Keyspace, connectionID string) (map[string]string, error) {
+	claims := make(map[string]string)
+	key := ks.ConnectionKey(connectionID, "claims")
+	err := redis.Do(ctx, s.redis, "HGETALL", radix.Cmd(&claims, "HGETALL", key))
+	return claims, err
+}
+
+// Delete removes the claims stored for the connection.
+func (s *ClaimStore) Delete(ctx context.Context, ks redis.Keyspace, connectionID string) error {
+	key := ks.ConnectionKey(connectionID, "claims")
+	return redis.Do(ctx, s.redis, "DEL", radix.Cmd(nil, "DEL", key))
+}
@@ -0,0 +1,298 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package policy decides which connections may subscribe and publish to which channels. Rules match channel names by
+// patterns which may refer to the claims the API's Lambda authorizer returned for the connection, so that, for
+// example, only the user with the "sub" claim "42" may subscribe to "private-user-42".
+package policy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/channel"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Action is an action on a channel which is subject to the policy.
+type Action string
+
+const (
+	// Subscribe is the action of receiving the messages of a channel, by subscribing to it or fetching its history.
+	Subscribe Action = "subscribe"
+
+	// Publish is the action of publishing a message to a channel.
+	Publish Action = "publish"
+)
+
+// Effect is the decision of a rule.
+type Effect string
+
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// Rule allows or denies actions on the channels which match its pattern. The pattern matches channel names literally,
+// except for "*", which matches any run of characters, and "{claim}", which matches the value of the named claim of
+// the connection. A pattern which refers to a claim the connection does not have does not match. For example,
+// "org:{orgId}:*" matches all channels of the organization of the connection.
+type Rule struct {
+	Effect  Effect   `json:"effect"`
+	Actions []Action `json:"actions,omitempty"`
+	Channel string   `json:"channel"`
+}
+
+// validate verifies that the rule can be evaluated.
+func (r Rule) validate() error {
+	if r.Effect != Allow && r.Effect != Deny {
+		return fmt.Errorf("effect %q of channel %q: must be %s or %s", r.Effect, r.Channel, Allow, Deny)
+	}
+
+	for _, a := range r.Actions {
+		if a != Subscribe && a != Publish {
+			return fmt.Errorf("action %q of channel %q: must be %s or %s", a, r.Channel, Subscribe, Publish)
+		}
+	}
+
+	if r.Channel == "" || !balanced(r.Channel) {
+		return fmt.Errorf("channel %q: must be a non-empty pattern whose braces each enclose a claim name", r.Channel)
+	}
+
+	return nil
+}
+
+// balanced reports whether every "{" of the pattern is closed by a "}" after it, with a non-empty claim name and no
+// other brace in between, and whether every "}" closes a "{".
+func balanced(pattern string) bool {
+	for pattern != "" {
+		open, end := strings.IndexByte(pattern, '{'), strings.IndexByte(pattern, '}')
+		switch {
+		case open < 0:
+			return end < 0
+		case end < open+2 || strings.IndexByte(pattern[open+1:end], '{') >= 0:
+			return false
+		}
+
+		pattern = pattern[end+1:]
+	}
+
+	return true
+}
+
+// applies reports whether the rule applies to the action on the channel by a connection with the provided claims.
+func (r Rule) applies(action Action, channel string, claims map[string]string) bool {
+	return r.governs(action) && match(r.Channel, channel, claims)
+}
+
+// governs reports whether the rule applies to the action, regardless of the channel.
+func (r Rule) governs(action Action) bool {
+	if len(r.Actions) == 0 {
+		return true
+	}
+
+	for _, a := range r.Actions {
+		if a == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+// covers reports whether the rule matches every channel which matches the channel pattern, see channel.Match. As the
+// wildcards of the pattern can only be matched by a "*" of the rule, which matches any run of characters, a rule
+// which matches the pattern itself matches every channel it stands for, except for the channel without the segments
+// of a trailing MultiWildcard, which is checked separately. The claims must not contain wildcards.
+func (r Rule) covers(pattern string, claims map[string]string) bool {
+	if strings.Contains(r.Channel, channel.MultiWildcard) || !match(r.Channel, pattern, claims) {
+		return false
+	}
+
+	trimmed := strings.TrimSuffix(pattern, "."+channel.MultiWildcard)
+	return trimmed == pattern || match(r.Channel, trimmed, claims)
+}
+
+// overlaps reports whether the rule may match a channel which matches the channel pattern. It compares the literal
+// prefixes of the rule and the pattern, so it may report an overlap which does not exist, but never misses one.
+func (r Rule) overlaps(pattern string, claims map[string]string) bool {
+	var literal strings.Builder
+	for rest := r.Channel; rest != ""; {
+		switch rest[0] {
+		case '*':
+			return prefixed(literal.String(), pattern)
+		case '{':
+			end := strings.IndexByte(rest, '}')
+			value, ok := claims[rest[1:end]]
+			if !ok || value == "" {
+				return false
+			}
+
+			literal.WriteString(value)
+			rest = rest[end+1:]
+		default:
+			literal.WriteByte(rest[0])
+			rest = rest[1:]
+		}
+	}
+
+	return channel.Match(pattern, literal.String())
+}
+
+// prefixed reports whether a channel starting with the prefix may match the channel pattern, which is the case when
+// either of the prefix and the literal prefix of the pattern starts with the other.
+func prefixed(prefix, pattern string) bool {
+	literal := pattern
+	if i := strings.IndexAny(pattern, channel.SingleWildcard+channel.MultiWildcard); i >= 0 {
+		literal = strings.TrimSuffix(pattern[:i], ".")
+	}
+
+	return strings.HasPrefix(prefix, literal) || strings.HasPrefix(literal, prefix)
+}
+
+// match reports whether the channel name matches the pattern. See Rule.
+func match(pattern, name string, claims map[string]string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			pattern = pattern[1:]
+			for i := len(name); i >= 0; i-- {
+				if match(pattern, name[i:], claims) {
+					return true
+				}
+			}
+
+			return false
+		case '{':
+			end := strings.IndexByte(pattern, '}')
+			if end < 0 {
+				return false
+			}
+
+			value, ok := claims[pattern[1:end]]
+			if !ok || value == "" || !strings.HasPrefix(name, value) {
+				return false
+			}
+
+			pattern, name = pattern[end+1:], name[len(value):]
+		default:
+			if name == "" || name[0] != pattern[0] {
+				return false
+			}
+
+			pattern, name = pattern[1:], name[1:]
+		}
+	}
+
+	return name == ""
+}
+
+// Engine evaluates the policy for the connections of all tenants. A nil Engine allows every action.
+type Engine struct {
+	claims *ClaimStore
+	opts   Options
+}
+
+// NewEngine creates a new Engine. The rules of the provided Options must have been validated, as OptionsFromEnv does.
+func NewEngine(client redis.Client, opts Options) *Engine {
+	if opts.Default == "" {
+		opts.Default = Allow
+	}
+
+	return &Engine{claims: NewClaimStore(client), opts: opts}
+}
+
+// Remember stores the claims of the connect request, against which the later actions of the connection are evaluated.
+// Nothing is stored when there are no rules.
+func (e *Engine) Remember(ctx context.Context, ks redis.Keyspace, req *events.APIGatewayWebsocketProxyRequest) error {
+	if e == nil || len(e.opts.Rules) == 0 {
+		return nil
+	}
+
+	return e.claims.Save(ctx, ks, req)
+}
+
+// Enforced reports whether there are rules to evaluate. Without rules, every action is decided by the default effect,
+// regardless of the connection.
+func (e *Engine) Enforced() bool {
+	return e != nil && len(e.opts.Rules) > 0
+}
+
+// Authorize reports whether the connection may take the action on the channel. The claims of the connection are only
+// read when there are rules to evaluate. The action on a channel pattern, see channel.Match, is only allowed if it is
+// allowed on every channel the pattern matches: the first rule which covers the whole pattern must allow it, and no
+// rule before it may deny the action on any channel of the pattern. When no rule covers the pattern, no rule may deny
+// the action on any of its channels, and the default effect must allow it.
+func (e *Engine) Authorize(ctx context.Context, ks redis.Keyspace, connectionID string, action Action,
+	name string) (bool, error) {
+	if e == nil {
+		return true, nil
+	}
+
+	if len(e.opts.Rules) == 0 {
+		return e.opts.Default == Allow, nil
+	}
+
+	claims, err := e.claims.Load(ctx, ks, connectionID)
+	if err != nil {
+		return false, err
+	}
+
+	if channel.IsPattern(name) {
+		return e.authorizePattern(action, name, claims), nil
+	}
+
+	for _, r := range e.opts.Rules {
+		if r.applies(action, name, claims) {
+			return r.Effect == Allow, nil
+		}
+	}
+
+	return e.opts.Default == Allow, nil
+}
+
+// authorizePattern reports whether the connection with the provided claims may take the action on every channel
+// which matches the pattern. See Authorize.
+func (e *Engine) authorizePattern(action Action, pattern string, claims map[string]string) bool {
+	// Channel names never contain wildcards, so a rule referring to a claim with a wildcard matches no channel.
+	literal := make(map[string]string, len(claims))
+	for k, v := range claims {
+		if !channel.IsPattern(v) {
+			literal[k] = v
+		}
+	}
+
+	claims = literal
+	for _, r := range e.opts.Rules {
+		switch {
+		case !r.governs(action):
+		case r.Effect == Deny && r.overlaps(pattern, claims):
+			return false
+		case r.Effect == Allow && r.covers(pattern, claims):
+			return true
+		}
+	}
+
+	return e.opts.Default == Allow
+}
@@ -0,0 +1,198 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package policy
+
+import (
+	"context"
+	"testing"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+)
+
+func TestMatch(t *testing.T) {
+	claims := map[string]string{"sub": "42", "orgId": "acme", "empty": ""}
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"news", "news", true},
+		{"news", "news2", false},
+		{"news", "new", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"news-*", "news-sports", true},
+		{"news-*", "news-", true},
+		{"news-*", "weather-sports", false},
+		{"*-sports", "news-sports", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"private-user-{sub}", "private-user-42", true},
+		{"private-user-{sub}", "private-user-43", false},
+		{"private-user-{sub}", "private-user-420", false},
+		{"org:{orgId}:*", "org:acme:orders", true},
+		{"org:{orgId}:*", "org:globex:orders", false},
+		{"{orgId}-{sub}", "acme-42", true},
+		{"private-user-{missing}", "private-user-", false},
+		{"private-user-{empty}", "private-user-", false},
+		{"private-user-{sub", "private-user-42", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			if got := match(tt.pattern, tt.name, claims); got != tt.want {
+				t.Errorf("match(%q, %q) = %t, want %t", tt.pattern, tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	tests := []struct {
+		channel string
+		valid   bool
+	}{
+		{"news", true},
+		{"news-*", true},
+		{"private-user-{sub}", true},
+		{"{orgId}-{sub}", true},
+		{"", false},
+		{"{}", false},
+		{"}{", false},
+		{"a}b{c", false},
+		{"{sub", false},
+		{"sub}", false},
+		{"{{sub}}", false},
+		{"{a{b}", false},
+		{"{sub}}", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			err := Rule{Effect: Allow, Channel: tt.channel}.validate()
+			if (err == nil) != tt.valid {
+				t.Errorf("validate(%q) = %v, want valid %t", tt.channel, err, tt.valid)
+			}
+		})
+	}
+
+	if err := (Rule{Effect: "maybe", Channel: "news"}).validate(); err == nil {
+		t.Error("validate accepted an unknown effect")
+	}
+
+	if err := (Rule{Effect: Allow, Actions: []Action{"delete"}, Channel: "news"}).validate(); err == nil {
+		t.Error("validate accepted an unknown action")
+	}
+}
+
+func TestRuleApplies(t *testing.T) {
+	r := Rule{Effect: Deny, Actions: []Action{Publish}, Channel: "news"}
+	if !r.applies(Publish, "news", nil) {
+		t.Error("rule does not apply to its action")
+	}
+
+	if r.applies(Subscribe, "news", nil) {
+		t.Error("rule applies to an action it does not list")
+	}
+
+	if !(Rule{Effect: Deny, Channel: "news"}).applies(Subscribe, "news", nil) {
+		t.Error("rule without actions does not apply to every action")
+	}
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Setenv(EnvRules, `[{"effect": "allow", "actions": ["subscribe"], "channel": "private-user-{sub}"}]`)
+	t.Setenv(EnvDefault, "deny")
+	opts, err := OptionsFromEnv()
+	if err != nil {
+		t.Fatalf("OptionsFromEnv failed: %v", err)
+	}
+
+	if len(opts.Rules) != 1 || opts.Rules[0].Channel != "private-user-{sub}" || opts.Default != Deny {
+		t.Errorf("got options %+v", opts)
+	}
+
+	for _, rules := range []string{`[{"effect": "allow", "channel": "}{"}]`, `{}`} {
+		t.Setenv(EnvRules, rules)
+		if _, err := OptionsFromEnv(); err == nil {
+			t.Errorf("OptionsFromEnv accepted %s %s", EnvRules, rules)
+		}
+	}
+}
+
+func TestAuthorizeWithoutRules(t *testing.T) {
+	ctx, ks := context.Background(), redis.Tenant("acme")
+	var nilEngine *Engine
+	if ok, err := nilEngine.Authorize(ctx, ks, "conn1", Publish, "news"); !ok || err != nil {
+		t.Errorf("nil engine: got %t, %v, want allowed", ok, err)
+	}
+
+	deny := NewEngine(nil, Options{Default: Deny})
+	if ok, err := deny.Authorize(ctx, ks, "conn1", Publish, "news"); ok || err != nil {
+		t.Errorf("default deny: got %t, %v, want denied", ok, err)
+	}
+}
+
+func TestAuthorizePattern(t *testing.T) {
+	rules := []Rule{
+		{Effect: Allow, Channel: "private-user-{sub}"},
+		{Effect: Deny, Channel: "private-user-*"},
+		{Effect: Allow, Actions: []Action{Subscribe}, Channel: "org.{orgId}.*"},
+		{Effect: Deny, Channel: "org.*"},
+	}
+
+	claims := map[string]string{"sub": "42", "orgId": "acme", "wild": "#"}
+	tests := []struct {
+		rules   []Rule
+		def     Effect
+		pattern string
+		want    bool
+	}{
+		{rules, Allow, "org.acme.*", true},
+		{rules, Allow, "org.acme.*.#", true},
+		{rules, Allow, "org.acme.#", false},
+		{rules, Allow, "org.*", false},
+		{rules, Allow, "org.globex.#", false},
+		{rules, Allow, "#", false},
+		{rules, Allow, "news.*", true},
+		{rules, Deny, "news.*", false},
+		{[]Rule{{Effect: Deny, Channel: "admin.*"}}, Allow, "*", false},
+		{[]Rule{{Effect: Deny, Channel: "admin.*"}}, Allow, "public.#", true},
+		{[]Rule{{Effect: Deny, Channel: "admin"}}, Allow, "public.#", true},
+		{[]Rule{{Effect: Deny, Channel: "admin"}}, Allow, "*", false},
+		{[]Rule{{Effect: Deny, Channel: "admin"}}, Allow, "*.*", true},
+		{[]Rule{{Effect: Deny, Channel: "admin"}}, Allow, "#", false},
+		{[]Rule{{Effect: Deny, Actions: []Action{Publish}, Channel: "*"}}, Allow, "#", true},
+		{[]Rule{{Effect: Allow, Channel: "*"}}, Deny, "#", true},
+		{[]Rule{{Effect: Allow, Channel: "devices.#"}}, Deny, "devices.#", false},
+		{[]Rule{{Effect: Allow, Channel: "devices.{wild}"}}, Deny, "devices.#", false},
+	}
+
+	for _, tt := range tests {
+		e := NewEngine(nil, Options{Rules: tt.rules, Default: tt.def})
+		if got := e.authorizePattern(Subscribe, tt.pattern, claims); got != tt.want {
+			t.Errorf("authorizePattern(%q) with %v and default %s = %v, want %v", tt.pattern, tt.rules, tt.def, got,
+				tt.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Environment variables read by OptionsFromEnv.
+const (
+	EnvRules   = "CHANNEL_POLICY"
+	EnvDefault = "CHANNEL_POLICY_DEFAULT"
+)
+
+// Options configures the Engine.
+type Options struct {
+	// Rules are evaluated in order, and the first rule which applies to an action on a channel decides whether the
+	// action is allowed.
+	Rules []Rule
+
+	// Default decides actions to which no rule applies.
+	Default Effect
+}
+
+// DefaultOptions returns the Options used when no environment variables are set, which allow every action.
+func DefaultOptions() Options {
+	return Options{Default: Allow}
+}
+
+// OptionsFromEnv returns DefaultOptions overridden by any of the CHANNEL_POLICY* environment variables which are set.
+// The rules are read as a JSON array, for example:
+//
+//	[{"effect": "allow", "actions": ["subscribe"], "channel": "private-user-{sub}"}]
+func OptionsFromEnv() (Options, error) {
+	opts := DefaultOptions()
+	if v := os.Getenv(EnvRules); v != "" {
+		if err := json.Unmarshal([]byte(v), &opts.Rules); err != nil {
+			return opts, fmt.Errorf("invalid %s %q: %w", EnvRules, v, err)
+		}
+
+		for _, r := range opts.Rules {
+			if err := r.validate(); err != nil {
+				return opts, fmt.Errorf("invalid %s %q: %w", EnvRules, v, err)
+			}
+		}
+	}
+
+	if v := os.Getenv(EnvDefault); v != "" {
+		switch e := Effect(v); e {
+		case Allow, Deny:
+			opts.Default = e
+		default:
+			return opts, fmt.Errorf("invalid %s %q: must be %s or %s", EnvDefault, v, Allow, Deny)
+		}
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,158 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package ratelimit paces the requests to the Amazon API Gateway Management API across all concurrent AWS Lambda
+// instances, so that large fan-outs stay under the account's quota instead of being throttled. The instances share a
+// token bucket in Redis, from which each instance takes small batches of tokens to avoid a round trip per request.
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/logger"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	radix "github.com/mediocregopher/radix/v3"
+	"go.uber.org/zap"
+)
+
+// lease is the period for which an instance takes tokens at once. Tokens which are not used within the lease are
+// discarded, so that an instance can not save up tokens beyond the burst of the bucket.
+const lease = 100 * time.Millisecond
+
+// takeScript refills the token bucket for the time since it was last refilled, up to the burst, and takes up to the
+// requested number of tokens from it. The script replies with the number of tokens taken, and when none could be taken,
+// with the number of milliseconds until the next token is available.
+var takeScript = radix.NewEvalScript(1, `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) * rate / 1000)
+	ts = now
+end
+local taken = math.min(tonumber(ARGV[4]), math.floor(tokens))
+tokens = tokens - taken
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", ts)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+local wait = 0
+if taken == 0 then
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+return {taken, wait}
+`)
+
+// Limiter limits the rate of requests shared by all instances using the same Redis. A nil Limiter does not limit the
+// rate.
+type Limiter struct {
+	redis redis.Client
+	opts  Options
+	batch int
+
+	mu      sync.Mutex
+	tokens  int
+	expires time.Time
+}
+
+// NewLimiter creates a new Limiter. It returns nil when the Options do not limit the rate.
+func NewLimiter(client redis.Client, opts Options) *Limiter {
+	if opts.Rate <= 0 {
+		return nil
+	}
+
+	if opts.Burst <= 0 {
+		opts.Burst = opts.Rate
+	}
+
+	batch := int(float64(opts.Rate) * lease.Seconds())
+	if batch < 1 {
+		batch = 1
+	}
+
+	if batch > opts.Burst {
+		batch = opts.Burst
+	}
+
+	return &Limiter{redis: client, opts: opts, batch: batch}
+}
+
+// Wait blocks until a request may be made, or returns the error of the context if it is done first. Failures of Redis
+// are logged and do not block the request, as the deliveries are worth more than staying under the quota.
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	for {
+		wait, err := l.take(ctx)
+		if err != nil {
+			logger.Sampled(ctx).Error("failed to take rate limit token", zap.Error(err))
+			return nil
+		}
+
+		if wait == 0 {
+			return nil
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// take takes a token of the current lease, taking the tokens of a new lease from the bucket once it is used up or
+// expired. It returns the duration to wait before trying again when the bucket is empty. Concurrent callers wait for
+// the first to take the tokens of the new lease, as they would otherwise all call Redis at once.
+func (l *Limiter) take(ctx context.Context) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.tokens > 0 && now.Before(l.expires) {
+		l.tokens--
+		return 0, nil
+	}
+
+	var reply []int64
+	ms := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	err := redis.Do(ctx, l.redis, "EVALSHA", takeScript.Cmd(&reply, redis.RateLimitKey, strconv.Itoa(l.opts.Rate),
+		strconv.Itoa(l.opts.Burst), ms, strconv.Itoa(l.batch)))
+	if err != nil || len(reply) != 2 {
+		return 0, err
+	}
+
+	if reply[0] == 0 {
+		return time.Duration(reply[1]) * time.Millisecond, nil
+	}
+
+	l.tokens, l.expires = int(reply[0])-1, now.Add(lease)
+	return 0, nil
+}
@@ -0,0 +1,143 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/redis/redistest"
+)
+
+// bucket returns an in-memory client which runs the take script against a token bucket, along with the number of
+// times the script was run.
+func bucket() (*redistest.Store, *int) {
+	client := redistest.NewStore()
+	calls := 0
+	client.Eval = func(_ string, keys, args []string) interface{} {
+		calls++
+		rate, _ := strconv.ParseFloat(args[0], 64)
+		burst, _ := strconv.ParseFloat(args[1], 64)
+		now, _ := strconv.ParseFloat(args[2], 64)
+		requested, _ := strconv.ParseFloat(args[3], 64)
+
+		state := client.Hashes[keys[0]]
+		if state == nil {
+			state = map[string]string{"tokens": args[1], "ts": args[2]}
+			client.Hashes[keys[0]] = state
+		}
+
+		tokens, _ := strconv.ParseFloat(state["tokens"], 64)
+		ts, _ := strconv.ParseFloat(state["ts"], 64)
+		if now > ts {
+			tokens, ts = math.Min(burst, tokens+(now-ts)*rate/1000), now
+		}
+
+		taken := math.Min(requested, math.Floor(tokens))
+		tokens -= taken
+		state["tokens"], state["ts"] = strconv.FormatFloat(tokens, 'f', -1, 64), strconv.FormatFloat(ts, 'f', -1, 64)
+
+		wait := 0.0
+		if taken == 0 {
+			wait = math.Ceil((1 - tokens) * 1000 / rate)
+		}
+
+		return []int64{int64(taken), int64(wait)}
+	}
+
+	return client, &calls
+}
+
+func TestNewLimiterWithoutRate(t *testing.T) {
+	l := NewLimiter(redistest.NewStore(), Options{})
+	if l != nil {
+		t.Fatalf("NewLimiter returned %v, want nil without a rate", l)
+	}
+
+	if err := l.Wait(context.Background()); err != nil {
+		t.Errorf("Wait of nil Limiter returned %v", err)
+	}
+}
+
+func TestWaitRefill(t *testing.T) {
+	client, _ := bucket()
+	l := NewLimiter(client, Options{Rate: 20, Burst: 3})
+	ctx := context.Background()
+
+	// The burst is available at once.
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatalf("Wait returned error: %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+		t.Errorf("burst took %v, want no wait", elapsed)
+	}
+
+	// The bucket is then refilled at the rate, one token every 50ms.
+	start = time.Now()
+	if err := l.Wait(ctx); err != nil {
+		t.Fatalf("Wait returned error: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("Wait on an empty bucket took %v, want about 50ms", elapsed)
+	}
+
+	// A context which is done before the next token is available ends the wait.
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestWaitBatch(t *testing.T) {
+	client, calls := bucket()
+	l := NewLimiter(client, Options{Rate: 1000})
+	for i := 0; i < 100; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait returned error: %v", err)
+		}
+	}
+
+	// The tokens of a lease, a tenth of the rate, are taken from the bucket at once.
+	if *calls != 1 {
+		t.Errorf("took tokens from the bucket %d times, want 1", *calls)
+	}
+}
+
+func TestWaitRedisFailure(t *testing.T) {
+	// Without Eval, the script fails, and the requests are not held back.
+	l := NewLimiter(redistest.NewStore(), Options{Rate: 1})
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Errorf("Wait returned %v, want nil when Redis fails", err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package ratelimit
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Environment variables read by OptionsFromEnv.
+const (
+	EnvRate  = "RATE_LIMIT"
+	EnvBurst = "RATE_LIMIT_BURST"
+)
+
+// Options configures the Limiter.
+type Options struct {
+	// Rate is the number of requests per second shared by all instances. Zero means no limit.
+	Rate int
+
+	// Burst is the number of requests which may be made at once after a period of inactivity. Zero means Rate.
+	Burst int
+}
+
+// DefaultOptions returns the Options used when no environment variables are set, which do not limit the rate.
+func DefaultOptions() Options {
+	return Options{}
+}
+
+// OptionsFromEnv returns DefaultOptions overridden by any of the RATE_LIMIT* environment variables which are set.
+func OptionsFromEnv() (Options, error) {
+	opts := DefaultOptions()
+	for name, n := range map[string]*int{
+		EnvRate:  &opts.Rate,
+		EnvBurst: &opts.Burst,
+	} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed < 0 {
+				return opts, fmt.Errorf("invalid %s %q: must be a non-negative integer", name, v)
+			}
+
+			*n = parsed
+		}
+	}
+
+	return opts, nil
+}
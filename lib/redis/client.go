@@ -20,43 +20,261 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
-// Package redis provides a singleton Redis client instance which is used across the same AWS Lambda execution contexts.
-// Reusing the client across execution contexts provides some performance enhancements due to reusing the underlying
-// connection to the Redis cluster.
+// Package redis provides constructors for the Redis client used by the AWS Lambda handlers. The client is created once
+// per execution context by the handler's main function and shared across subsequent invocations. Reusing the client
+// across execution contexts provides some performance enhancements due to reusing the underlying connection to the
+// Redis cluster.
 package redis
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"strings"
 
 	"com.aws-samples/apigateway.websockets.golang/lib/logger"
 	"github.com/mediocregopher/radix/v3"
 	"go.uber.org/zap"
 )
 
-// Client is the single client instance shared across the same Lambda execution contexts.
-var Client *radix.Pool
+// Client is a Redis client which can route read-only commands to replicas. Do always executes the action against the
+// primary, while DoRead executes the action against a replica when replica reads are enabled, and against the primary
+// otherwise. Only use DoRead for commands which tolerate replication lag, such as reading the members of a set.
+type Client interface {
+	radix.Client
+	DoRead(a radix.Action) error
+}
 
-func init() {
-	cname, servers, err := net.LookupSRV("redis", "tcp", "service.internal")
+// NewClient returns a client connected to the Redis endpoint described by the provided Options. When no URL is
+// configured, the endpoints are discovered from the SRV record. The SRV targets are tried in order of priority, and by
+// weight within the same priority, until a connection is established. An error is returned if no endpoint is reachable.
+func NewClient(opts Options) (Client, error) {
+	addrs, db, err := endpoints(&opts, opts.URL, opts.Service)
 	if err != nil {
-		logger.Instance.Panic("unable to resolve redis srv record", zap.Error(err))
+		return nil, err
 	}
 
-	if len(servers) == 0 {
-		logger.Instance.Panic("unable to resolve redis srv record")
+	switch {
+	case opts.AuthFile != "":
+		opts.Username, opts.AuthToken, err = LoadCredentials(opts.AuthFile)
+	case opts.AuthSecret != "":
+		opts.Username, opts.AuthToken, err = LoadSecret(context.Background(), opts.AuthSecret)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	dialOpts, err := dialOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if db >= 0 {
+		dialOpts = append(dialOpts, radix.DialSelectDB(db))
+	}
+
+	if opts.Cluster {
+		return newClusterClient(addrs, opts, dialOpts)
+	}
+
+	primary, err := newPool(addrs, opts, dialOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &poolClient{Client: primary, replica: primary}
+	if !opts.ReadFromReplicas {
+		return client, nil
+	}
+
+	readerAddrs, _, err := endpoints(&opts, opts.ReaderURL, opts.ReaderService)
+	if err != nil {
+		_ = primary.Close()
+		return nil, fmt.Errorf("unable to resolve redis replicas: %w", err)
+	}
+
+	client.replica, err = newPool(readerAddrs, opts, dialOpts)
+	if err != nil {
+		_ = primary.Close()
+		return nil, fmt.Errorf("unable to connect to redis replicas: %w", err)
+	}
+
+	return client, nil
+}
+
+// poolClient is a Client backed by a connection pool to the primary and, optionally, a connection pool to the
+// replicas. When replica reads are disabled, replica refers to the same pool as the primary.
+type poolClient struct {
+	radix.Client
+	replica radix.Client
+}
+
+// DoRead executes the action against the replicas.
+func (c *poolClient) DoRead(a radix.Action) error {
+	return c.replica.Do(a)
+}
+
+// Close closes the connection pools.
+func (c *poolClient) Close() error {
+	err := c.Client.Close()
+	if c.replica != c.Client {
+		if rerr := c.replica.Close(); err == nil {
+			err = rerr
+		}
+	}
+
+	return err
+}
+
+// clusterClient is a Client backed by a Redis Cluster. Keys which must be accessed together, for example by a script or
+// a transaction, must share a hash tag so they are stored in the same slot. See Key.
+type clusterClient struct {
+	*radix.Cluster
+	readFromReplicas bool
+}
+
+// DoRead executes the action against a replica of the node owning the action's key.
+func (c *clusterClient) DoRead(a radix.Action) error {
+	if c.readFromReplicas {
+		return c.Cluster.DoSecondary(a)
+	}
+
+	return c.Cluster.Do(a)
+}
+
+// newClusterClient creates a client for the cluster reachable through any of the provided addresses. Each node of the
+// cluster is accessed through its own connection pool.
+func newClusterClient(addrs []string, opts Options, dialOpts []radix.DialOpt) (Client, error) {
+	cluster, err := radix.NewCluster(addrs, radix.ClusterPoolFunc(func(network, addr string) (radix.Client, error) {
+		return radix.NewPool(network, addr, opts.PoolSize, connFunc(dialOpts))
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create redis cluster client: %w", err)
+	}
+
+	logger.Instance.Info("redis cluster client created",
+		zap.Strings("addrs", addrs),
+		zap.Int("size", opts.PoolSize),
+		zap.Bool("tls", opts.TLS),
+		zap.Bool("readFromReplicas", opts.ReadFromReplicas))
+
+	return &clusterClient{Cluster: cluster, readFromReplicas: opts.ReadFromReplicas}, nil
+}
+
+// newPool creates a connection pool to the first reachable address. The addresses are tried in the order provided.
+func newPool(addrs []string, opts Options, dialOpts []radix.DialOpt) (*radix.Pool, error) {
+	var lastErr error
+	for _, addr := range addrs {
+		pool, err := radix.NewPool("tcp", addr, opts.PoolSize, connFunc(dialOpts))
+		if err == nil {
+			logger.Instance.Info("redis connection pool created",
+				zap.String("addr", addr),
+				zap.Int("size", opts.PoolSize),
+				zap.Bool("tls", opts.TLS))
+
+			return pool, nil
+		}
+
+		logger.Instance.Warn("unable to connect to redis endpoint", zap.String("addr", addr), zap.Error(err))
+		lastErr = err
 	}
 
-	logger.Instance.Info("redis srv record",
-		zap.String("cname", cname),
-		zap.Uint16("port", servers[0].Port),
-		zap.String("target", servers[0].Target),
-		zap.Uint16("weight", servers[0].Weight),
-		zap.Uint16("priority", servers[0].Priority))
+	return nil, fmt.Errorf("unable to create redis connection pool for any of %d endpoints: %w", len(addrs), lastErr)
+}
+
+// connFunc returns the pool option used to dial each connection in the pool.
+func connFunc(dialOpts []radix.DialOpt) radix.PoolOpt {
+	return radix.PoolConnFunc(func(network, addr string) (radix.Conn, error) {
+		return radix.Dial(network, addr, dialOpts...)
+	})
+}
+
+// endpoints returns the addresses to connect to and the selected database. The addresses are taken from the provided
+// URL if it is not empty, and are otherwise resolved from the SRV record of the provided service. A database index of
+// -1 indicates no database was selected.
+func endpoints(opts *Options, url, service string) ([]string, int, error) {
+	if url != "" {
+		addr, db, err := opts.applyURL(url)
+		if err != nil {
+			return nil, -1, err
+		}
+
+		return []string{addr}, db, nil
+	}
+
+	addrs, err := lookupAddrs(service, opts.Proto, opts.Name, opts.TLS && opts.TLSServerName == "")
+	return addrs, -1, err
+}
 
-	addr := net.JoinHostPort(servers[0].Target, fmt.Sprintf("%d", servers[0].Port))
-	Client, err = radix.NewPool("tcp", addr, 1)
+// lookupAddrs resolves the SRV record and returns the target addresses. The resolver orders the records by priority and
+// randomizes them by weight within the same priority, so the returned addresses are in the order they should be tried.
+// When canonical is true, each target is replaced by its canonical name.
+func lookupAddrs(service, proto, name string, canonical bool) ([]string, error) {
+	cname, servers, err := net.LookupSRV(service, proto, name)
 	if err != nil {
-		logger.Instance.Panic("unable to create redis connection pool", zap.Error(err))
+		return nil, fmt.Errorf("unable to resolve redis srv record: %w", err)
 	}
+
+	if len(servers) == 0 {
+		return nil, errors.New("unable to resolve redis srv record")
+	}
+
+	addrs := make([]string, 0, len(servers))
+	for _, server := range servers {
+		logger.Instance.Info("redis srv record",
+			zap.String("cname", cname),
+			zap.Uint16("port", server.Port),
+			zap.String("target", server.Target),
+			zap.Uint16("weight", server.Weight),
+			zap.Uint16("priority", server.Priority))
+
+		target := server.Target
+		if canonical {
+			target, err = net.LookupCNAME(target)
+			if err != nil {
+				return nil, fmt.Errorf("unable to resolve canonical name of %s: %w", server.Target, err)
+			}
+		}
+
+		addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(target, "."), fmt.Sprintf("%d", server.Port)))
+	}
+
+	return addrs, nil
+}
+
+// dialOptions converts the provided Options into the options used to dial each connection in the pool.
+func dialOptions(opts Options) ([]radix.DialOpt, error) {
+	var dialOpts []radix.DialOpt
+	if opts.DialTimeout > 0 {
+		dialOpts = append(dialOpts, radix.DialConnectTimeout(opts.DialTimeout))
+	}
+
+	if opts.ReadTimeout > 0 {
+		dialOpts = append(dialOpts, radix.DialReadTimeout(opts.ReadTimeout))
+	}
+
+	if opts.WriteTimeout > 0 {
+		dialOpts = append(dialOpts, radix.DialWriteTimeout(opts.WriteTimeout))
+	}
+
+	if opts.TLS {
+		cfg, err := tlsConfig(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		dialOpts = append(dialOpts, radix.DialUseTLS(cfg))
+	}
+
+	if opts.AuthToken != "" {
+		if opts.Username != "" {
+			dialOpts = append(dialOpts, radix.DialAuthUser(opts.Username, opts.AuthToken))
+		} else {
+			dialOpts = append(dialOpts, radix.DialAuthPass(opts.AuthToken))
+		}
+	}
+
+	return dialOpts, nil
 }
@@ -0,0 +1,128 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/external"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// credentials is the JSON structure of a credentials file. The keys match those of the secrets AWS Secrets Manager
+// creates for ElastiCache users.
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// LoadCredentials reads the username and AUTH token from the file at the provided path. A file containing a JSON object
+// is decoded as a secret with "username" and "password" keys. Any other content is used as the AUTH token, ignoring
+// leading and trailing white space.
+func LoadCredentials(path string) (username, token string, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to read redis credentials: %w", err)
+	}
+
+	return parseCredentials(data)
+}
+
+// LoadSecret reads the username and AUTH token from the AWS Secrets Manager secret with the provided ID or ARN, which
+// holds them in the same form as the file read by LoadCredentials. The secret is read with the SDK default
+// configuration.
+func LoadSecret(ctx context.Context, id string) (username, token string, err error) {
+	cfg, err := external.LoadDefaultAWSConfig()
+	if err != nil {
+		return "", "", fmt.Errorf("unable to load SDK config: %w", err)
+	}
+
+	res, err := secretsmanager.New(cfg).GetSecretValueRequest(&secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	}).Send(ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to read redis credentials secret: %w", err)
+	}
+
+	data := res.SecretBinary
+	if res.SecretString != nil {
+		data = []byte(*res.SecretString)
+	}
+
+	return parseCredentials(data)
+}
+
+// parseCredentials decodes the username and AUTH token of a credentials file or secret.
+func parseCredentials(data []byte) (username, token string, err error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return "", "", errors.New("redis credentials are empty")
+	}
+
+	if data[0] != '{' {
+		return "", string(data), nil
+	}
+
+	var c credentials
+	if err := json.Unmarshal(data, &c); err != nil {
+		return "", "", fmt.Errorf("unable to decode redis credentials: %w", err)
+	}
+
+	if c.Password == "" {
+		return "", "", errors.New("redis credentials do not contain a password")
+	}
+
+	return c.Username, c.Password, nil
+}
+
+// tlsConfig returns the TLS configuration used to dial the Redis endpoints.
+func tlsConfig(opts Options) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: opts.TLSServerName,
+	}
+
+	if opts.TLSCAFile == "" {
+		return cfg, nil
+	}
+
+	pem, err := ioutil.ReadFile(opts.TLSCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read redis ca bundle: %w", err)
+	}
+
+	cfg.RootCAs = x509.NewCertPool()
+	if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("redis ca bundle %s contains no certificates", opts.TLSCAFile)
+	}
+
+	return cfg, nil
+}
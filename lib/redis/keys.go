@@ -0,0 +1,105 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import "strings"
+
+// RedeliveriesKey is the key of the sorted set holding the unacknowledged deliveries of all tenants, scored by the time
+// they are due to be redelivered. It is the only key which is not namespaced per tenant, so that a single scheduled
+// invocation can find the due deliveries of all tenants.
+const RedeliveriesKey = "redeliveries"
+
+// ScheduledKey is the key of the sorted set holding the scheduled messages of all tenants, scored by the time they are
+// due to be published, so that a single scheduled invocation can find the due messages of all tenants.
+const ScheduledKey = "scheduled"
+
+// RateLimitKey is the key of the token bucket which paces the requests to the Amazon API Gateway Management API. The
+// quota of the API applies to the whole account, so the bucket is shared by all tenants.
+const RateLimitKey = "ratelimit"
+
+// ConnectionTenantKey builds the key holding the tenant of a connection, which is used to resolve the tenant of
+// requests which do not carry it. Connection IDs are unique across tenants, so the key is not namespaced per tenant.
+func ConnectionTenantKey(id string) string {
+	return Key("connection:"+id, "tenant")
+}
+
+// Keyspace builds the keys of a single tenant. All keys of a tenant start with the tenant's prefix, which lies outside
+// of the hash tag of the key, so that keys which are used together are still stored in the same hash slot in cluster
+// mode. Tenants can not read or write each other's keys, as long as all keys are built from their Keyspace.
+type Keyspace struct {
+	prefix string
+}
+
+// Tenant returns the Keyspace of the provided tenant. The tenant ID must not hold braces or white space.
+func Tenant(id string) Keyspace {
+	return Keyspace{prefix: "tenant:" + id + ":"}
+}
+
+// Key builds a key of the tenant from the provided hash tag and parts. See Key.
+func (k Keyspace) Key(tag string, parts ...string) string {
+	return k.prefix + Key(tag, parts...)
+}
+
+// ConnectionsKey returns the key of the set holding the IDs of the connected clients of the tenant.
+func (k Keyspace) ConnectionsKey() string {
+	return k.prefix + "connections"
+}
+
+// DeadLettersKey returns the key of the list holding the deliveries of the tenant which were never acknowledged.
+func (k Keyspace) DeadLettersKey() string {
+	return k.prefix + "deadletters"
+}
+
+// ChannelKey builds the key of the provided part of the state of a channel, for example ChannelKey(name, "seq"). All
+// keys of the same channel share a hash tag.
+func (k Keyspace) ChannelKey(name string, parts ...string) string {
+	return k.Key("channel:"+name, parts...)
+}
+
+// ConnectionKey builds the key of the provided part of the state of a connection, for example
+// ConnectionKey(id, "pending"). All keys of the same connection share a hash tag.
+func (k Keyspace) ConnectionKey(id string, parts ...string) string {
+	return k.Key("connection:"+id, parts...)
+}
+
+// MessageKey builds the key of the provided part of the state of a message, for example MessageKey(id, "seen"). All
+// keys of the same message share a hash tag.
+func (k Keyspace) MessageKey(id string, parts ...string) string {
+	return k.Key("message:"+id, parts...)
+}
+
+// Key builds a Redis key from the provided hash tag and parts. The tag is wrapped in braces so that in cluster mode all
+// keys built from the same tag are stored in the same hash slot, which allows them to be used together in scripts and
+// transactions. For example, Key("chat", "members") returns "{chat}:members".
+func Key(tag string, parts ...string) string {
+	var b strings.Builder
+	b.WriteString("{")
+	b.WriteString(tag)
+	b.WriteString("}")
+	for _, part := range parts {
+		b.WriteString(":")
+		b.WriteString(part)
+	}
+
+	return b.String()
+}
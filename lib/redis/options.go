@@ -0,0 +1,225 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Environment variables read by OptionsFromEnv.
+const (
+	EnvURL          = "REDIS_URL"
+	EnvSRVService   = "REDIS_SRV_SERVICE"
+	EnvSRVProto     = "REDIS_SRV_PROTO"
+	EnvSRVName      = "REDIS_SRV_NAME"
+	EnvPoolSize     = "REDIS_POOL_SIZE"
+	EnvDialTimeout  = "REDIS_DIAL_TIMEOUT"
+	EnvReadTimeout  = "REDIS_READ_TIMEOUT"
+	EnvWriteTimeout = "REDIS_WRITE_TIMEOUT"
+	EnvTLS          = "REDIS_TLS"
+	EnvTLSCAFile    = "REDIS_TLS_CA_FILE"
+	EnvTLSServer    = "REDIS_TLS_SERVER_NAME"
+	EnvUsername     = "REDIS_USERNAME"
+	EnvAuthToken    = "REDIS_AUTH_TOKEN"
+	EnvAuthFile     = "REDIS_AUTH_FILE"
+	EnvAuthSecret   = "REDIS_AUTH_SECRET"
+	EnvCluster      = "REDIS_CLUSTER"
+	EnvReadReplicas = "REDIS_READ_FROM_REPLICAS"
+	EnvReaderURL    = "REDIS_READER_URL"
+	EnvReaderSRV    = "REDIS_READER_SRV_SERVICE"
+)
+
+// Options configures the client returned by NewClient.
+type Options struct {
+	// URL overrides the SRV record lookup with a single Redis endpoint. The URL has the form
+	// redis://[username:password@]host:port[/db]. The rediss scheme enables TLS. This is mostly useful for local
+	// development against a Redis server which is not registered in the application's private hosted zone.
+	URL string
+
+	// Service, Proto and Name identify the SRV record which is resolved to discover the Redis endpoints, for example
+	// _redis._tcp.service.internal.
+	Service string
+	Proto   string
+	Name    string
+
+	// PoolSize is the number of connections held open by the connection pool.
+	PoolSize int
+
+	// DialTimeout, ReadTimeout and WriteTimeout bound the time spent establishing a connection and reading from or
+	// writing to it. A zero value uses the client library's default.
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// TLS enables TLS when dialing the Redis endpoints. The server certificate is verified against the system's root
+	// CAs, or against the PEM encoded certificates in TLSCAFile when it is set. When TLSServerName is empty, SRV
+	// targets are resolved to their canonical names so the certificate of an endpoint reached through a CNAME, such as
+	// redis.service.internal, is verified against the endpoint's own host name.
+	TLS           bool
+	TLSCAFile     string
+	TLSServerName string
+
+	// Username and AuthToken are sent with the AUTH command once a connection is established. Username selects a Redis
+	// ACL user and may be empty when only an AUTH token is configured.
+	Username  string
+	AuthToken string
+
+	// AuthFile is the path of a file holding the credentials, which take precedence over Username and AuthToken. The
+	// file holds either the plain AUTH token or a JSON object in the style of an AWS Secrets Manager secret, with
+	// "username" and "password" keys. See LoadCredentials.
+	AuthFile string
+
+	// AuthSecret is the ID or ARN of an AWS Secrets Manager secret holding the credentials in the same form as AuthFile.
+	// The secret is read when the client is created, and takes precedence over Username and AuthToken, but not over
+	// AuthFile. See LoadSecret.
+	AuthSecret string
+
+	// Cluster connects to a cluster mode enabled deployment. The URL or SRV record must resolve to the cluster's
+	// configuration endpoint, or any of its nodes, from which the cluster topology is discovered.
+	Cluster bool
+
+	// ReadFromReplicas routes read-only commands issued with Client.DoRead to replicas. In cluster mode the replicas are
+	// discovered from the cluster topology. Otherwise the replicas are reached through ReaderURL or, when ReaderURL is
+	// empty, through the SRV record identified by ReaderService, Proto and Name. Reads from replicas may lag behind the
+	// primary.
+	ReadFromReplicas bool
+	ReaderURL        string
+	ReaderService    string
+}
+
+// DefaultOptions returns the Options matching the infrastructure deployed by the application's template.
+func DefaultOptions() Options {
+	return Options{
+		Service:       "redis",
+		Proto:         "tcp",
+		Name:          "service.internal",
+		PoolSize:      1,
+		DialTimeout:   5 * time.Second,
+		ReaderService: "redis-reader",
+	}
+}
+
+// OptionsFromEnv returns DefaultOptions overridden by any of the REDIS_* environment variables which are set. Timeouts
+// are parsed with time.ParseDuration, for example "500ms". An error is returned if a variable can not be parsed.
+func OptionsFromEnv() (Options, error) {
+	opts := DefaultOptions()
+
+	opts.URL = os.Getenv(EnvURL)
+	opts.ReaderURL = os.Getenv(EnvReaderURL)
+	lookupString(EnvReaderSRV, &opts.ReaderService)
+	lookupString(EnvSRVService, &opts.Service)
+	lookupString(EnvSRVProto, &opts.Proto)
+	lookupString(EnvSRVName, &opts.Name)
+	lookupString(EnvUsername, &opts.Username)
+	lookupString(EnvAuthToken, &opts.AuthToken)
+	lookupString(EnvAuthFile, &opts.AuthFile)
+	lookupString(EnvAuthSecret, &opts.AuthSecret)
+	lookupString(EnvTLSCAFile, &opts.TLSCAFile)
+	lookupString(EnvTLSServer, &opts.TLSServerName)
+
+	if v, ok := os.LookupEnv(EnvPoolSize); ok {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 {
+			return opts, fmt.Errorf("invalid %s %q: must be a positive integer", EnvPoolSize, v)
+		}
+
+		opts.PoolSize = size
+	}
+
+	for name, b := range map[string]*bool{
+		EnvTLS:          &opts.TLS,
+		EnvCluster:      &opts.Cluster,
+		EnvReadReplicas: &opts.ReadFromReplicas,
+	} {
+		if v, ok := os.LookupEnv(name); ok {
+			enabled, err := strconv.ParseBool(v)
+			if err != nil {
+				return opts, fmt.Errorf("invalid %s %q: %w", name, v, err)
+			}
+
+			*b = enabled
+		}
+	}
+
+	for name, d := range map[string]*time.Duration{
+		EnvDialTimeout:  &opts.DialTimeout,
+		EnvReadTimeout:  &opts.ReadTimeout,
+		EnvWriteTimeout: &opts.WriteTimeout,
+	} {
+		if v, ok := os.LookupEnv(name); ok {
+			parsed, err := time.ParseDuration(v)
+			if err != nil {
+				return opts, fmt.Errorf("invalid %s %q: %w", name, v, err)
+			}
+
+			*d = parsed
+		}
+	}
+
+	return opts, nil
+}
+
+// lookupString sets the value pointed to by v to the named environment variable if the variable is set and not empty.
+func lookupString(name string, v *string) {
+	if s := os.Getenv(name); s != "" {
+		*v = s
+	}
+}
+
+// applyURL copies the credentials and TLS setting from the provided URL into the Options and returns the endpoint
+// address and database index. A database index of -1 indicates the URL did not select a database.
+func (o *Options) applyURL(raw string) (addr string, db int, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", -1, fmt.Errorf("invalid redis url: %w", err)
+	}
+
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		o.TLS = true
+	default:
+		return "", -1, fmt.Errorf("invalid redis url scheme %q", u.Scheme)
+	}
+
+	if u.User != nil {
+		o.Username = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			o.AuthToken = password
+		}
+	}
+
+	db = -1
+	if u.Path != "" && u.Path != "/" {
+		db, err = strconv.Atoi(u.Path[1:])
+		if err != nil {
+			return "", -1, fmt.Errorf("invalid redis url database %q", u.Path[1:])
+		}
+	}
+
+	return u.Host, db, nil
+}
@@ -0,0 +1,463 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package redistest provides an in-memory Redis client for the tests of the packages which use Redis. It implements
+// the commands used by this module on strings, sets, hashes, lists and sorted sets, without expiry. Lua scripts are
+// run by the Eval function of the Store, which the tests emulate as needed.
+package redistest
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// Store is an in-memory Redis client. The zero value is not usable, see NewStore. The exported maps may be read and
+// seeded by tests, but not while a command is running.
+type Store struct {
+	mu sync.Mutex
+
+	Strings map[string]string
+	Sets    map[string]map[string]bool
+	Hashes  map[string]map[string]string
+	Lists   map[string][]string
+	ZSets   map[string]map[string]float64
+
+	// Eval runs the Lua script with the provided keys and arguments, and returns its reply. The Store is locked
+	// while it runs, so it may access the maps directly. Scripts fail when Eval is nil.
+	Eval func(script string, keys, args []string) interface{}
+
+	// Fail holds the errors returned by commands instead of running them.
+	Fail map[string]error
+}
+
+// NewStore creates a new, empty Store.
+func NewStore() *Store {
+	return &Store{
+		Strings: make(map[string]string),
+		Sets:    make(map[string]map[string]bool),
+		Hashes:  make(map[string]map[string]string),
+		Lists:   make(map[string][]string),
+		ZSets:   make(map[string]map[string]float64),
+		Fail:    make(map[string]error),
+	}
+}
+
+// Do runs the action against the Store.
+func (s *Store) Do(a radix.Action) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return a.Run(radix.Stub("", "", s.exec))
+}
+
+// DoRead runs the action against the Store, which has no replicas.
+func (s *Store) DoRead(a radix.Action) error { return s.Do(a) }
+
+// Close does nothing.
+func (s *Store) Close() error { return nil }
+
+// SortedSet returns the members of the sorted set in order of their scores.
+func (s *Store) SortedSet(key string) []string {
+	members := make([]string, 0, len(s.ZSets[key]))
+	for m := range s.ZSets[key] {
+		members = append(members, m)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		a, b := s.ZSets[key][members[i]], s.ZSets[key][members[j]]
+		return a < b || (a == b && members[i] < members[j])
+	})
+
+	return members
+}
+
+func (s *Store) exec(args []string) interface{} {
+	cmd, args := strings.ToUpper(args[0]), args[1:]
+	if err := s.Fail[cmd]; err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "EVALSHA":
+		// Make the client send the script itself.
+		return errors.New("NOSCRIPT No matching script")
+	case "EVAL":
+		if s.Eval == nil {
+			return errors.New("ERR scripts are not supported")
+		}
+
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+
+		return s.Eval(args[0], args[2:2+n], args[2+n:])
+	case "GET":
+		if v, ok := s.Strings[args[0]]; ok {
+			return v
+		}
+
+		return nil
+	case "SET":
+		_, exists := s.Strings[args[0]]
+		for _, opt := range args[2:] {
+			if o := strings.ToUpper(opt); (o == "NX" && exists) || (o == "XX" && !exists) {
+				return nil
+			}
+		}
+
+		s.Strings[args[0]] = args[1]
+		return "OK"
+	case "INCR":
+		n, _ := strconv.ParseInt(s.Strings[args[0]], 10, 64)
+		s.Strings[args[0]] = strconv.FormatInt(n+1, 10)
+		return n + 1
+	case "DEL":
+		removed := 0
+		for _, key := range args {
+			if s.exists(key) {
+				removed++
+			}
+
+			delete(s.Strings, key)
+			delete(s.Sets, key)
+			delete(s.Hashes, key)
+			delete(s.Lists, key)
+			delete(s.ZSets, key)
+		}
+
+		return removed
+	case "EXISTS":
+		n := 0
+		for _, key := range args {
+			if s.exists(key) {
+				n++
+			}
+		}
+
+		return n
+	case "PEXPIRE", "EXPIRE", "PEXPIREAT", "EXPIREAT":
+		if s.exists(args[0]) {
+			return 1
+		}
+
+		return 0
+	case "SADD":
+		if s.Sets[args[0]] == nil {
+			s.Sets[args[0]] = make(map[string]bool)
+		}
+
+		added := 0
+		for _, m := range args[1:] {
+			if !s.Sets[args[0]][m] {
+				s.Sets[args[0]][m] = true
+				added++
+			}
+		}
+
+		return added
+	case "SREM":
+		removed := 0
+		for _, m := range args[1:] {
+			if s.Sets[args[0]][m] {
+				delete(s.Sets[args[0]], m)
+				removed++
+			}
+		}
+
+		if len(s.Sets[args[0]]) == 0 {
+			delete(s.Sets, args[0])
+		}
+
+		return removed
+	case "SMEMBERS", "SUNION":
+		seen := make(map[string]bool)
+		members := []string{}
+		for _, key := range args {
+			for m := range s.Sets[key] {
+				if !seen[m] {
+					seen[m] = true
+					members = append(members, m)
+				}
+			}
+		}
+
+		sort.Strings(members)
+		return members
+	case "SISMEMBER":
+		if s.Sets[args[0]][args[1]] {
+			return 1
+		}
+
+		return 0
+	case "SCARD":
+		return len(s.Sets[args[0]])
+	case "HSET":
+		if s.Hashes[args[0]] == nil {
+			s.Hashes[args[0]] = make(map[string]string)
+		}
+
+		added := 0
+		for i := 1; i+1 < len(args); i += 2 {
+			if _, ok := s.Hashes[args[0]][args[i]]; !ok {
+				added++
+			}
+
+			s.Hashes[args[0]][args[i]] = args[i+1]
+		}
+
+		return added
+	case "HGET":
+		if v, ok := s.Hashes[args[0]][args[1]]; ok {
+			return v
+		}
+
+		return nil
+	case "HGETALL":
+		fields := []string{}
+		for k, v := range s.Hashes[args[0]] {
+			fields = append(fields, k, v)
+		}
+
+		return fields
+	case "HDEL":
+		removed := 0
+		for _, f := range args[1:] {
+			if _, ok := s.Hashes[args[0]][f]; ok {
+				delete(s.Hashes[args[0]], f)
+				removed++
+			}
+		}
+
+		if len(s.Hashes[args[0]]) == 0 {
+			delete(s.Hashes, args[0])
+		}
+
+		return removed
+	case "LPUSH":
+		for _, v := range args[1:] {
+			s.Lists[args[0]] = append([]string{v}, s.Lists[args[0]]...)
+		}
+
+		return len(s.Lists[args[0]])
+	case "LRANGE":
+		list := s.Lists[args[0]]
+		start, stop := span(args[1], args[2], len(list))
+		if start > stop {
+			return []string{}
+		}
+
+		return append([]string{}, list[start:stop+1]...)
+	case "LTRIM":
+		list := s.Lists[args[0]]
+		start, stop := span(args[1], args[2], len(list))
+		if start > stop {
+			delete(s.Lists, args[0])
+		} else {
+			s.Lists[args[0]] = list[start : stop+1]
+		}
+
+		return "OK"
+	case "ZADD":
+		return s.zadd(args[0], args[1:])
+	case "ZREM":
+		removed := 0
+		for _, m := range args[1:] {
+			if _, ok := s.ZSets[args[0]][m]; ok {
+				delete(s.ZSets[args[0]], m)
+				removed++
+			}
+		}
+
+		if len(s.ZSets[args[0]]) == 0 {
+			delete(s.ZSets, args[0])
+		}
+
+		return removed
+	case "ZSCORE":
+		if score, ok := s.ZSets[args[0]][args[1]]; ok {
+			return strconv.FormatFloat(score, 'f', -1, 64)
+		}
+
+		return nil
+	case "ZCARD":
+		return len(s.ZSets[args[0]])
+	case "ZRANGEBYSCORE":
+		return s.zrangeByScore(args[0], args[1:])
+	case "ZREMRANGEBYRANK":
+		members := s.SortedSet(args[0])
+		start, stop := span(args[1], args[2], len(members))
+		removed := 0
+		for i := start; i <= stop; i++ {
+			delete(s.ZSets[args[0]], members[i])
+			removed++
+		}
+
+		return removed
+	}
+
+	return errors.New("ERR unknown command " + cmd)
+}
+
+func (s *Store) exists(key string) bool {
+	_, str := s.Strings[key]
+	_, set := s.Sets[key]
+	_, hash := s.Hashes[key]
+	_, list := s.Lists[key]
+	_, zset := s.ZSets[key]
+	return str || set || hash || list || zset
+}
+
+func (s *Store) zadd(key string, args []string) interface{} {
+	flags := make(map[string]bool)
+	for len(args) > 0 {
+		switch flag := strings.ToUpper(args[0]); flag {
+		case "NX", "XX", "GT", "LT", "CH":
+			flags[flag] = true
+			args = args[1:]
+			continue
+		}
+
+		break
+	}
+
+	nx, xx, gt, lt := flags["NX"], flags["XX"], flags["GT"], flags["LT"]
+	if s.ZSets[key] == nil {
+		s.ZSets[key] = make(map[string]float64)
+	}
+
+	added, changed := 0, 0
+	for i := 0; i+1 < len(args); i += 2 {
+		score, err := strconv.ParseFloat(args[i], 64)
+		if err != nil {
+			return errors.New("ERR value is not a valid float")
+		}
+
+		old, ok := s.ZSets[key][args[i+1]]
+		switch {
+		case ok && (nx || (gt && score <= old) || (lt && score >= old)), !ok && xx:
+			continue
+		case !ok:
+			added++
+		case old != score:
+			changed++
+		}
+
+		s.ZSets[key][args[i+1]] = score
+	}
+
+	if len(s.ZSets[key]) == 0 {
+		delete(s.ZSets, key)
+	}
+
+	if flags["CH"] {
+		return added + changed
+	}
+
+	return added
+}
+
+func (s *Store) zrangeByScore(key string, args []string) interface{} {
+	min, minExcl := bound(args[0])
+	max, maxExcl := bound(args[1])
+	var scores bool
+	offset, count := 0, -1
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "WITHSCORES":
+			scores = true
+		case "LIMIT":
+			offset, _ = strconv.Atoi(args[i+1])
+			count, _ = strconv.Atoi(args[i+2])
+			i += 2
+		}
+	}
+
+	reply := []string{}
+	for _, m := range s.SortedSet(key) {
+		score := s.ZSets[key][m]
+		if score < min || score > max || (minExcl && score == min) || (maxExcl && score == max) {
+			continue
+		}
+
+		if offset > 0 {
+			offset--
+			continue
+		}
+
+		if count == 0 {
+			break
+		}
+
+		count--
+		reply = append(reply, m)
+		if scores {
+			reply = append(reply, strconv.FormatFloat(score, 'f', -1, 64))
+		}
+	}
+
+	return reply
+}
+
+// bound parses a score bound of ZRANGEBYSCORE, and reports whether it is exclusive.
+func bound(v string) (float64, bool) {
+	excl := strings.HasPrefix(v, "(")
+	v = strings.TrimPrefix(v, "(")
+	switch v {
+	case "-inf":
+		return -1e308, excl
+	case "+inf", "inf":
+		return 1e308, excl
+	}
+
+	f, _ := strconv.ParseFloat(v, 64)
+	return f, excl
+}
+
+// span resolves the possibly negative start and stop indices of a list of length n. The span is empty if the start
+// is after the stop.
+func span(start, stop string, n int) (int, int) {
+	i, _ := strconv.Atoi(start)
+	j, _ := strconv.Atoi(stop)
+	if i < 0 {
+		i += n
+	}
+
+	if j < 0 {
+		j += n
+	}
+
+	if i < 0 {
+		i = 0
+	}
+
+	if j >= n {
+		j = n - 1
+	}
+
+	return i, j
+}
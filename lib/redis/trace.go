@@ -0,0 +1,57 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/tracing"
+	"github.com/mediocregopher/radix/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Do executes the action against the primary within a span named after the provided operation, typically the Redis
+// command.
+func Do(ctx context.Context, c Client, op string, a radix.Action) error {
+	return do(ctx, op, false, func() error { return c.Do(a) })
+}
+
+// DoRead executes the action with Client.DoRead within a span named after the provided operation, typically the Redis
+// command.
+func DoRead(ctx context.Context, c Client, op string, a radix.Action) error {
+	return do(ctx, op, true, func() error { return c.DoRead(a) })
+}
+
+func do(ctx context.Context, op string, read bool, fn func() error) error {
+	_, span := tracing.Tracer().Start(ctx, "redis "+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("db.operation.name", op),
+			attribute.Bool("db.redis.replica_read", read)))
+
+	err := fn()
+	tracing.End(span, err)
+	return err
+}
@@ -0,0 +1,80 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package schedule
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Environment variables read by OptionsFromEnv.
+const (
+	EnvMaxDelay = "SCHEDULE_MAX_DELAY"
+	EnvGrace    = "SCHEDULE_GRACE"
+	EnvLease    = "SCHEDULE_LEASE"
+)
+
+// Options configures the Store.
+type Options struct {
+	// MaxDelay is how far into the future a message can be scheduled.
+	MaxDelay time.Duration
+
+	// Grace is how long a message is kept after its delivery time for a late dispatch, for example when the scheduled
+	// invocations were throttled.
+	Grace time.Duration
+
+	// Lease is how long a claimed message is withheld from other dispatchers. A message which was not published by
+	// then, for example as the dispatcher failed, is claimed again.
+	Lease time.Duration
+}
+
+// DefaultOptions returns the Options used when no environment variables are set.
+func DefaultOptions() Options {
+	return Options{
+		MaxDelay: 7 * 24 * time.Hour,
+		Grace:    time.Hour,
+		Lease:    5 * time.Minute,
+	}
+}
+
+// OptionsFromEnv returns DefaultOptions overridden by any of the SCHEDULE_* environment variables which are set.
+func OptionsFromEnv() (Options, error) {
+	opts := DefaultOptions()
+	for name, d := range map[string]*time.Duration{
+		EnvMaxDelay: &opts.MaxDelay,
+		EnvGrace:    &opts.Grace,
+		EnvLease:    &opts.Lease,
+	} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil || parsed < time.Second {
+				return opts, fmt.Errorf("invalid %s %q: must be a duration of at least 1s", name, v)
+			}
+
+			*d = parsed
+		}
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,192 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package schedule holds messages which are published at a later time. The message is stored in the keyspace of its
+// tenant, and its entry in a sorted set shared by all tenants, scored by its delivery time, so that a single scheduled
+// invocation can find the due messages of all tenants.
+package schedule
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// leaseScript moves the due entry ARGV[1] of the sorted set KEYS[1] to the lease deadline ARGV[3], provided that its
+// score is at or before ARGV[2]. It returns 1 if the entry was leased, and 0 if it is not due or no longer scheduled.
+var leaseScript = radix.NewEvalScript(1, `
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if not score or tonumber(score) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[3], ARGV[1])
+return 1
+`)
+
+// ErrTooLate is returned by Add when the delivery time is further into the future than the maximum delay.
+var ErrTooLate = errors.New("delivery time too far in the future")
+
+// Entry identifies a scheduled message of a tenant.
+type Entry struct {
+	Tenant    string
+	MessageID string
+}
+
+// key returns the key of the message of the entry.
+func (e Entry) key() string {
+	return redis.Tenant(e.Tenant).MessageKey(e.MessageID, "scheduled")
+}
+
+// member encodes the entry as a member of the scheduled sorted set. Tenant IDs never contain spaces.
+func (e Entry) member() string {
+	return e.Tenant + " " + e.MessageID
+}
+
+// Store keeps scheduled messages in Redis.
+type Store struct {
+	redis redis.Client
+	opts  Options
+}
+
+// NewStore creates a new Store. Zero values in the provided Options are replaced by the values of DefaultOptions.
+func NewStore(client redis.Client, opts Options) *Store {
+	defaults := DefaultOptions()
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = defaults.MaxDelay
+	}
+
+	if opts.Grace <= 0 {
+		opts.Grace = defaults.Grace
+	}
+
+	if opts.Lease <= 0 {
+		opts.Lease = defaults.Lease
+	}
+
+	return &Store{redis: client, opts: opts}
+}
+
+// Add schedules the message of the entry for delivery at the provided time. Scheduling a message again replaces its
+// payload and delivery time.
+func (s *Store) Add(ctx context.Context, e Entry, at time.Time, payload []byte) error {
+	if time.Until(at) > s.opts.MaxDelay {
+		return ErrTooLate
+	}
+
+	// The message is stored before its entry is added, so that a due entry always finds its message.
+	ttl := strconv.FormatInt((time.Until(at) + s.opts.Grace).Milliseconds(), 10)
+	err := redis.Do(ctx, s.redis, "SET", radix.FlatCmd(nil, "SET", e.key(), payload, "PX", ttl))
+	if err != nil {
+		return err
+	}
+
+	return redis.Do(ctx, s.redis, "ZADD", radix.Cmd(nil, "ZADD", redis.ScheduledKey,
+		strconv.FormatInt(at.UnixMilli(), 10), e.member()))
+}
+
+// Due returns up to limit entries whose delivery time, or the end of whose lease, is at or before the provided time.
+func (s *Store) Due(ctx context.Context, now time.Time, limit int) ([]Entry, error) {
+	var members []string
+	err := redis.Do(ctx, s.redis, "ZRANGEBYSCORE", radix.Cmd(&members, "ZRANGEBYSCORE", redis.ScheduledKey,
+		"-inf", strconv.FormatInt(now.UnixMilli(), 10), "LIMIT", "0", strconv.Itoa(limit)))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(members))
+	for _, m := range members {
+		parts := strings.SplitN(m, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		entries = append(entries, Entry{Tenant: parts[0], MessageID: parts[1]})
+	}
+
+	return entries, nil
+}
+
+// Load returns the payload of the scheduled message. It reports false if the message is not scheduled.
+func (s *Store) Load(ctx context.Context, e Entry) ([]byte, bool, error) {
+	var payload []byte
+	stored := radix.MaybeNil{Rcv: &payload}
+	if err := redis.Do(ctx, s.redis, "GET", radix.Cmd(&stored, "GET", e.key())); err != nil {
+		return nil, false, err
+	}
+
+	return payload, !stored.Nil, nil
+}
+
+// Claim leases the due entry and returns the payload of its message. The entry is moved to the end of its lease in
+// the scheduled sorted set, so that it is not due again until the lease expired, and must be completed once its message
+// was published. A message which is not completed, as its dispatcher failed, is claimed again after the lease, until
+// it expires after the grace period. Only one of several concurrent callers claims the entry, the others, and callers
+// whose entry was canceled in the meantime, are returned false.
+func (s *Store) Claim(ctx context.Context, e Entry, now time.Time) ([]byte, bool, error) {
+	var leased int
+	err := redis.Do(ctx, s.redis, "EVALSHA", leaseScript.Cmd(&leased, redis.ScheduledKey, e.member(),
+		strconv.FormatInt(now.UnixMilli(), 10), strconv.FormatInt(now.Add(s.opts.Lease).UnixMilli(), 10)))
+	if err != nil || leased == 0 {
+		return nil, false, err
+	}
+
+	payload, ok, err := s.Load(ctx, e)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !ok {
+		// The message expired or was canceled, so its entry is dropped.
+		_, err := s.remove(ctx, e)
+		return nil, false, err
+	}
+
+	return payload, true, nil
+}
+
+// Complete removes the claimed entry and its message once the message was published.
+func (s *Store) Complete(ctx context.Context, e Entry) error {
+	_, err := s.Cancel(ctx, e)
+	return err
+}
+
+// Cancel removes the entry and its message. It reports whether the message was still scheduled.
+func (s *Store) Cancel(ctx context.Context, e Entry) (bool, error) {
+	ok, err := s.remove(ctx, e)
+	if err != nil {
+		return false, err
+	}
+
+	return ok, redis.Do(ctx, s.redis, "DEL", radix.Cmd(nil, "DEL", e.key()))
+}
+
+// remove removes the entry from the scheduled sorted set. It reports whether the entry was removed by this call.
+func (s *Store) remove(ctx context.Context, e Entry) (bool, error) {
+	var removed int
+	err := redis.Do(ctx, s.redis, "ZREM", radix.Cmd(&removed, "ZREM", redis.ScheduledKey, e.member()))
+	return removed > 0, err
+}
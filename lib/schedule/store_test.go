@@ -0,0 +1,198 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package schedule
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis/redistest"
+)
+
+// newStore returns a Store backed by an in-memory client which runs the lease script.
+func newStore() (*Store, *redistest.Store) {
+	client := redistest.NewStore()
+	client.Eval = func(_ string, keys, args []string) interface{} {
+		score, ok := client.ZSets[keys[0]][args[0]]
+		now, _ := strconv.ParseFloat(args[1], 64)
+		if !ok || score > now {
+			return 0
+		}
+
+		client.ZSets[keys[0]][args[0]], _ = strconv.ParseFloat(args[2], 64)
+		return 1
+	}
+
+	return NewStore(client, Options{Lease: time.Minute}), client
+}
+
+func TestAdd(t *testing.T) {
+	s, client := newStore()
+	e := Entry{Tenant: "acme", MessageID: "m1"}
+	at := time.Now().Add(time.Hour)
+	if err := s.Add(context.Background(), e, at, []byte("payload")); err != nil {
+		t.Fatalf("Add returned error: %v", err)
+	}
+
+	if got := client.ZSets[redis.ScheduledKey][e.member()]; got != float64(at.UnixMilli()) {
+		t.Errorf("entry scored %v, want %d", got, at.UnixMilli())
+	}
+
+	if got := client.Strings[e.key()]; got != "payload" {
+		t.Errorf("message stored as %q, want %q", got, "payload")
+	}
+
+	if err := s.Add(context.Background(), e, time.Now().Add(8*24*time.Hour), nil); err != ErrTooLate {
+		t.Errorf("Add beyond the maximum delay returned %v, want %v", err, ErrTooLate)
+	}
+}
+
+func TestDue(t *testing.T) {
+	s, _ := newStore()
+	ctx := context.Background()
+	now := time.Now()
+	for i, d := range []time.Duration{-2 * time.Minute, -time.Minute, time.Minute} {
+		e := Entry{Tenant: "acme", MessageID: "m" + strconv.Itoa(i)}
+		if err := s.Add(ctx, e, now.Add(d), nil); err != nil {
+			t.Fatalf("Add returned error: %v", err)
+		}
+	}
+
+	due, err := s.Due(ctx, now, 10)
+	want := []Entry{{Tenant: "acme", MessageID: "m0"}, {Tenant: "acme", MessageID: "m1"}}
+	if err != nil || !reflect.DeepEqual(due, want) {
+		t.Errorf("Due returned %v, %v, want %v", due, err, want)
+	}
+
+	if due, err := s.Due(ctx, now, 1); err != nil || len(due) != 1 {
+		t.Errorf("Due with limit 1 returned %v, %v", due, err)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	s, client := newStore()
+	ctx := context.Background()
+	e := Entry{Tenant: "acme", MessageID: "m1"}
+	now := time.Now()
+	if err := s.Add(ctx, e, now.Add(-time.Second), []byte("payload")); err != nil {
+		t.Fatalf("Add returned error: %v", err)
+	}
+
+	payload, ok, err := s.Claim(ctx, e, now)
+	if err != nil || !ok || string(payload) != "payload" {
+		t.Fatalf("Claim returned %q, %v, %v, want the payload", payload, ok, err)
+	}
+
+	// The claimed entry is leased rather than removed, so that it is not lost if the message fails to publish.
+	if got := client.ZSets[redis.ScheduledKey][e.member()]; got != float64(now.Add(time.Minute).UnixMilli()) {
+		t.Errorf("claimed entry scored %v, want the end of its lease", got)
+	}
+
+	if _, ok, err := s.Claim(ctx, e, now); ok || err != nil {
+		t.Errorf("second Claim returned %v, %v, want false", ok, err)
+	}
+
+	if due, err := s.Due(ctx, now, 10); err != nil || len(due) != 0 {
+		t.Errorf("Due returned the leased entry: %v, %v", due, err)
+	}
+
+	// Once the lease expired, the entry is due and can be claimed again.
+	later := now.Add(2 * time.Minute)
+	if due, err := s.Due(ctx, later, 10); err != nil || len(due) != 1 {
+		t.Errorf("Due after the lease returned %v, %v, want the entry", due, err)
+	}
+
+	if _, ok, err := s.Claim(ctx, e, later); !ok || err != nil {
+		t.Errorf("Claim after the lease returned %v, %v, want true", ok, err)
+	}
+
+	if err := s.Complete(ctx, e); err != nil {
+		t.Fatalf("Complete returned error: %v", err)
+	}
+
+	if _, ok := client.ZSets[redis.ScheduledKey][e.member()]; ok {
+		t.Error("completed entry is still scheduled")
+	}
+
+	if _, ok := client.Strings[e.key()]; ok {
+		t.Error("completed message is still stored")
+	}
+}
+
+func TestClaimWithoutMessage(t *testing.T) {
+	s, client := newStore()
+	ctx := context.Background()
+	e := Entry{Tenant: "acme", MessageID: "m1"}
+	now := time.Now()
+	if err := s.Add(ctx, e, now.Add(-time.Second), nil); err != nil {
+		t.Fatalf("Add returned error: %v", err)
+	}
+
+	// The message expired, so its entry is dropped.
+	delete(client.Strings, e.key())
+	if _, ok, err := s.Claim(ctx, e, now); ok || err != nil {
+		t.Errorf("Claim returned %v, %v, want false", ok, err)
+	}
+
+	if _, ok := client.ZSets[redis.ScheduledKey][e.member()]; ok {
+		t.Error("entry without message is still scheduled")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	s, client := newStore()
+	ctx := context.Background()
+	e := Entry{Tenant: "acme", MessageID: "m1"}
+	if err := s.Add(ctx, e, time.Now().Add(time.Hour), nil); err != nil {
+		t.Fatalf("Add returned error: %v", err)
+	}
+
+	if ok, err := s.Cancel(ctx, e); !ok || err != nil {
+		t.Errorf("Cancel returned %v, %v, want true", ok, err)
+	}
+
+	if ok, err := s.Cancel(ctx, e); ok || err != nil {
+		t.Errorf("second Cancel returned %v, %v, want false", ok, err)
+	}
+
+	if _, ok := client.Strings[e.key()]; ok {
+		t.Error("canceled message is still stored")
+	}
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Setenv(EnvLease, "90s")
+	opts, err := OptionsFromEnv()
+	if err != nil || opts.Lease != 90*time.Second || opts.Grace != DefaultOptions().Grace {
+		t.Errorf("OptionsFromEnv returned %+v, %v", opts, err)
+	}
+
+	t.Setenv(EnvGrace, "10ms")
+	if _, err := OptionsFromEnv(); err == nil {
+		t.Error("OptionsFromEnv accepted a grace below 1s")
+	}
+}
@@ -0,0 +1,129 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package session removes the state of WebSocket connections from Redis once they are closed, and closes connections
+// on behalf of the server.
+package session
+
+import (
+	"context"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw"
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw/ws"
+	"com.aws-samples/apigateway.websockets.golang/lib/channel"
+	"com.aws-samples/apigateway.websockets.golang/lib/logger"
+	"com.aws-samples/apigateway.websockets.golang/lib/policy"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"com.aws-samples/apigateway.websockets.golang/lib/tenant"
+	"com.aws-samples/apigateway.websockets.golang/lib/user"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	radix "github.com/mediocregopher/radix/v3"
+	"go.uber.org/zap"
+)
+
+// closingMessage is the "message" of the ClosingEnvelop.
+const closingMessage = "server.closing"
+
+// Closer closes connections and removes their state.
+type Closer struct {
+	redis         redis.Client
+	tenants       *tenant.Resolver
+	users         *user.Limiter
+	subscriptions *channel.Subscriptions
+	claims        *policy.ClaimStore
+}
+
+// NewCloser creates a new Closer.
+func NewCloser(client redis.Client, tenants *tenant.Resolver, users *user.Limiter) *Closer {
+	return &Closer{
+		redis:         client,
+		tenants:       tenants,
+		users:         users,
+		subscriptions: channel.NewSubscriptions(client),
+		claims:        policy.NewClaimStore(client),
+	}
+}
+
+// Cleanup removes the state of a closed connection of the provided tenant from Redis: its membership in the connections
+// of the tenant and of its user, its subscriptions, its claims, and the record of its tenant. It reports whether the
+// connection was still among the connections of the tenant.
+func (c *Closer) Cleanup(ctx context.Context, ks redis.Keyspace, id string) (bool, error) {
+	var removed int
+	err := redis.Do(ctx, c.redis, "SREM", radix.Cmd(&removed, "SREM", ks.ConnectionsKey(), id))
+	if err != nil {
+		return false, err
+	}
+
+	if err := c.users.Disconnect(ctx, ks, id); err != nil {
+		return removed > 0, err
+	}
+
+	if err := c.subscriptions.Remove(ctx, ks, id); err != nil {
+		return removed > 0, err
+	}
+
+	if err := c.claims.Delete(ctx, ks, id); err != nil {
+		return removed > 0, err
+	}
+
+	return removed > 0, c.tenants.Disconnect(ctx, id)
+}
+
+// Close closes the connection on behalf of the server. The client is first sent a ClosingEnvelop with the provided code
+// and reason, then the connection is deleted with the Amazon API Gateway Management API, and finally its state is
+// removed with Cleanup. The notice is best effort, a connection which can not receive it is closed regardless. A
+// connection which no longer exists is not an error, its state is removed all the same.
+func (c *Closer) Close(ctx context.Context, client *apigatewaymanagementapi.Client, id, code, reason string) error {
+	log := logger.FromContext(ctx).With(zap.String("closedConnectionId", id), zap.String("code", code))
+
+	data, err := (&ws.ClosingEnvelop{Message: closingMessage, Code: code, Reason: reason}).Encode()
+	if err != nil {
+		return err
+	}
+
+	gone := false
+	if _, err := apigw.PostToConnection(ctx, client, id, data); err != nil {
+		gone = apigw.Classify(err) == apigw.Remove
+		if !gone {
+			log.Warn("failed to send closing notice", zap.Error(err))
+		}
+	}
+
+	if !gone {
+		if err := apigw.DeleteConnection(ctx, client, id); err != nil && apigw.Classify(err) != apigw.Remove {
+			return err
+		}
+	}
+
+	tenantID, err := c.tenants.Lookup(ctx, id)
+	if err == tenant.ErrUnknownConnection {
+		log.Info("skip cleanup of connection of unknown tenant")
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Cleanup(ctx, redis.Tenant(tenantID), id)
+	return err
+}
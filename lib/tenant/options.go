@@ -0,0 +1,117 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package tenant
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Environment variables read by OptionsFromEnv.
+const (
+	EnvAuthorizerKey    = "TENANT_AUTHORIZER_KEY"
+	EnvQueryParameter   = "TENANT_QUERY_PARAMETER"
+	EnvRequired         = "TENANT_REQUIRED"
+	EnvMaxConnections   = "TENANT_MAX_CONNECTIONS"
+	EnvConnectionLimits = "TENANT_CONNECTION_LIMITS"
+)
+
+// Options configures the Resolver.
+type Options struct {
+	// AuthorizerKey is the key of the tenant ID in the context returned by the Lambda authorizer of the API.
+	AuthorizerKey string
+
+	// QueryParameter is the query parameter of the connect request holding the tenant ID, which is used when the
+	// authorizer context does not hold one. Empty disables the parameter. The parameter is supplied by the client and is
+	// not authenticated, so any client can join any tenant with it. It should only be enabled for deployments whose
+	// clients are trusted.
+	QueryParameter string
+
+	// Required rejects connections without a tenant ID. Otherwise, such connections belong to the Default tenant.
+	Required bool
+
+	// MaxConnections is the maximum number of connections of each tenant. Zero means no limit.
+	MaxConnections int
+
+	// ConnectionLimits overrides MaxConnections for individual tenants.
+	ConnectionLimits map[string]int
+}
+
+// DefaultOptions returns the Options used when no environment variables are set.
+func DefaultOptions() Options {
+	return Options{
+		AuthorizerKey: "tenantId",
+	}
+}
+
+// OptionsFromEnv returns DefaultOptions overridden by any of the TENANT_* environment variables which are set. The
+// per-tenant limits are read from a comma separated list of tenant=limit pairs, for example "acme=1000,globex=50".
+func OptionsFromEnv() (Options, error) {
+	opts := DefaultOptions()
+	if v, ok := os.LookupEnv(EnvAuthorizerKey); ok {
+		opts.AuthorizerKey = v
+	}
+
+	if v, ok := os.LookupEnv(EnvQueryParameter); ok {
+		opts.QueryParameter = v
+	}
+
+	if v := os.Getenv(EnvRequired); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s %q: %w", EnvRequired, v, err)
+		}
+
+		opts.Required = b
+	}
+
+	if v := os.Getenv(EnvMaxConnections); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("invalid %s %q: must be a non-negative integer", EnvMaxConnections, v)
+		}
+
+		opts.MaxConnections = n
+	}
+
+	if v := os.Getenv(EnvConnectionLimits); v != "" {
+		opts.ConnectionLimits = make(map[string]int)
+		for _, pair := range strings.Split(v, ",") {
+			id, limit := strings.TrimSpace(pair), ""
+			if i := strings.IndexByte(id, '='); i >= 0 {
+				id, limit = id[:i], id[i+1:]
+			}
+
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 0 || Validate(id) != nil {
+				return opts, fmt.Errorf("invalid %s %q: must be a list of tenant=limit pairs", EnvConnectionLimits, v)
+			}
+
+			opts.ConnectionLimits[id] = n
+		}
+	}
+
+	return opts, nil
+}
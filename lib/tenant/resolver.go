@@ -0,0 +1,163 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package tenant isolates the connections and messages of the tenants of the application. The tenant of a connection
+// is resolved from the context of the API's Lambda authorizer or, on connect, from a query parameter, and all keys of
+// the tenant are built from its redis.Keyspace.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"github.com/aws/aws-lambda-go/events"
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// Default is the tenant of connections without a tenant ID, unless a tenant ID is required.
+const Default = "default"
+
+// MaxIDLength is the maximum length of a tenant ID.
+const MaxIDLength = 64
+
+// bindingTTL is how long the tenant of a connection is kept. Amazon API Gateway closes connections after 2 hours.
+const bindingTTL = 3 * time.Hour
+
+var (
+	// ErrInvalidID is returned for tenant IDs which can not be used to build keys.
+	ErrInvalidID = errors.New("invalid tenant id")
+
+	// ErrMissing is returned when a tenant ID is required but the request does not carry one.
+	ErrMissing = errors.New("missing tenant id")
+
+	// ErrUnknownConnection is returned when the tenant of a connection can not be found.
+	ErrUnknownConnection = errors.New("tenant of connection not found")
+)
+
+// Validate checks that the provided tenant ID can be used to build keys. IDs must not be longer than MaxIDLength and
+// may only hold ASCII letters, digits, '.', '_' and '-'.
+func Validate(id string) error {
+	if id == "" || len(id) > MaxIDLength {
+		return ErrInvalidID
+	}
+
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+		default:
+			return ErrInvalidID
+		}
+	}
+
+	return nil
+}
+
+// Resolver resolves the tenant of requests.
+type Resolver struct {
+	redis redis.Client
+	opts  Options
+}
+
+// NewResolver creates a new Resolver.
+func NewResolver(client redis.Client, opts Options) *Resolver {
+	return &Resolver{redis: client, opts: opts}
+}
+
+// Connect resolves the tenant of a connect request from the authorizer context or the query parameter, and records it
+// for the connection so that later requests of the connection resolve to the same tenant.
+func (r *Resolver) Connect(ctx context.Context, req *events.APIGatewayWebsocketProxyRequest) (string, error) {
+	id, ok := r.fromAuthorizer(req)
+	if !ok && r.opts.QueryParameter != "" {
+		id, ok = req.QueryStringParameters[r.opts.QueryParameter]
+	}
+
+	if !ok || id == "" {
+		if r.opts.Required {
+			return "", ErrMissing
+		}
+
+		id = Default
+	}
+
+	if err := Validate(id); err != nil {
+		return "", err
+	}
+
+	ttl := strconv.FormatInt(bindingTTL.Milliseconds(), 10)
+	key := redis.ConnectionTenantKey(req.RequestContext.ConnectionID)
+	return id, redis.Do(ctx, r.redis, "SET", radix.Cmd(nil, "SET", key, id, "PX", ttl))
+}
+
+// Resolve resolves the tenant of a request of an established connection. The authorizer context takes precedence,
+// otherwise the tenant recorded by Connect is used.
+func (r *Resolver) Resolve(ctx context.Context, req *events.APIGatewayWebsocketProxyRequest) (string, error) {
+	if id, ok := r.fromAuthorizer(req); ok {
+		return id, Validate(id)
+	}
+
+	return r.Lookup(ctx, req.RequestContext.ConnectionID)
+}
+
+// Lookup returns the tenant recorded for the connection by Connect. It is used to resolve the tenant of connections
+// outside of their requests, for example when the server closes them.
+func (r *Resolver) Lookup(ctx context.Context, connectionID string) (string, error) {
+	var id string
+	stored := radix.MaybeNil{Rcv: &id}
+	key := redis.ConnectionTenantKey(connectionID)
+	if err := redis.Do(ctx, r.redis, "GET", radix.Cmd(&stored, "GET", key)); err != nil {
+		return "", err
+	}
+
+	if stored.Nil {
+		return "", ErrUnknownConnection
+	}
+
+	return id, nil
+}
+
+// Disconnect removes the tenant recorded for the connection by Connect.
+func (r *Resolver) Disconnect(ctx context.Context, connectionID string) error {
+	return redis.Do(ctx, r.redis, "DEL", radix.Cmd(nil, "DEL", redis.ConnectionTenantKey(connectionID)))
+}
+
+// MaxConnections returns the maximum number of connections of the tenant. Zero means no limit.
+func (r *Resolver) MaxConnections(id string) int {
+	if n, ok := r.opts.ConnectionLimits[id]; ok {
+		return n
+	}
+
+	return r.opts.MaxConnections
+}
+
+// fromAuthorizer returns the tenant ID from the context of the Lambda authorizer, if any.
+func (r *Resolver) fromAuthorizer(req *events.APIGatewayWebsocketProxyRequest) (string, bool) {
+	claims, ok := req.RequestContext.Authorizer.(map[string]interface{})
+	if !ok || r.opts.AuthorizerKey == "" {
+		return "", false
+	}
+
+	id, ok := claims[r.opts.AuthorizerKey].(string)
+	return id, ok && id != ""
+}
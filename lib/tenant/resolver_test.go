@@ -0,0 +1,158 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package tenant
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis/redistest"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// request returns a connect request of the connection with the provided authorizer context and query parameters.
+func request(id string, claims map[string]interface{},
+	query map[string]string) *events.APIGatewayWebsocketProxyRequest {
+	req := &events.APIGatewayWebsocketProxyRequest{QueryStringParameters: query}
+	req.RequestContext.ConnectionID = id
+	if claims != nil {
+		req.RequestContext.Authorizer = claims
+	}
+
+	return req
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		id   string
+		want error
+	}{
+		{"acme", nil},
+		{"acme-corp_1.eu", nil},
+		{strings.Repeat("a", MaxIDLength), nil},
+		{"", ErrInvalidID},
+		{strings.Repeat("a", MaxIDLength+1), ErrInvalidID},
+		{"{acme}", ErrInvalidID},
+		{"acme:1", ErrInvalidID},
+		{"ac me", ErrInvalidID},
+	}
+
+	for _, tt := range tests {
+		if got := Validate(tt.id); got != tt.want {
+			t.Errorf("Validate(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestConnect(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		claims  map[string]interface{}
+		query   map[string]string
+		want    string
+		wantErr error
+	}{
+		{"authorizer", DefaultOptions(), map[string]interface{}{"tenantId": "acme"}, nil, "acme", nil},
+		{"default", DefaultOptions(), nil, nil, Default, nil},
+		{"query parameter disabled", DefaultOptions(), nil, map[string]string{"tenant": "acme"}, Default, nil},
+		{"query parameter", Options{QueryParameter: "tenant"}, nil, map[string]string{"tenant": "acme"}, "acme", nil},
+		{"authorizer over query parameter", Options{AuthorizerKey: "tenantId", QueryParameter: "tenant"},
+			map[string]interface{}{"tenantId": "acme"}, map[string]string{"tenant": "globex"}, "acme", nil},
+		{"required", Options{AuthorizerKey: "tenantId", Required: true}, nil, nil, "", ErrMissing},
+		{"invalid", DefaultOptions(), map[string]interface{}{"tenantId": "{acme}"}, nil, "", ErrInvalidID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := redistest.NewStore()
+			r := NewResolver(client, tt.opts)
+			id, err := r.Connect(context.Background(), request("conn1", tt.claims, tt.query))
+			if id != tt.want || err != tt.wantErr {
+				t.Fatalf("Connect returned %q, %v, want %q, %v", id, err, tt.want, tt.wantErr)
+			}
+
+			recorded, ok := client.Strings[redis.ConnectionTenantKey("conn1")]
+			if ok != (err == nil) || recorded != tt.want {
+				t.Errorf("recorded tenant %q, %v, want %q", recorded, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	client := redistest.NewStore()
+	r := NewResolver(client, DefaultOptions())
+	ctx := context.Background()
+	if _, err := r.Connect(ctx, request("conn1", nil, map[string]string{"tenant": "acme"})); err != nil {
+		t.Fatalf("Connect returned error: %v", err)
+	}
+
+	client.Strings[redis.ConnectionTenantKey("conn2")] = "acme"
+	if id, err := r.Resolve(ctx, request("conn2", nil, nil)); id != "acme" || err != nil {
+		t.Errorf("Resolve returned %q, %v, want the recorded tenant", id, err)
+	}
+
+	claims := map[string]interface{}{"tenantId": "globex"}
+	if id, err := r.Resolve(ctx, request("conn2", claims, nil)); id != "globex" || err != nil {
+		t.Errorf("Resolve returned %q, %v, want the tenant of the authorizer", id, err)
+	}
+
+	if err := r.Disconnect(ctx, "conn2"); err != nil {
+		t.Fatalf("Disconnect returned error: %v", err)
+	}
+
+	if _, err := r.Resolve(ctx, request("conn2", nil, nil)); err != ErrUnknownConnection {
+		t.Errorf("Resolve returned %v after Disconnect, want %v", err, ErrUnknownConnection)
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	r := NewResolver(nil, Options{MaxConnections: 100, ConnectionLimits: map[string]int{"acme": 1000, "free": 0}})
+	for id, want := range map[string]int{"acme": 1000, "free": 0, "globex": 100} {
+		if got := r.MaxConnections(id); got != want {
+			t.Errorf("MaxConnections(%q) = %d, want %d", id, got, want)
+		}
+	}
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Setenv(EnvRequired, "true")
+	t.Setenv(EnvConnectionLimits, "acme=1000, globex=50")
+	opts, err := OptionsFromEnv()
+	want := Options{AuthorizerKey: "tenantId", Required: true,
+		ConnectionLimits: map[string]int{"acme": 1000, "globex": 50}}
+	if err != nil || !reflect.DeepEqual(opts, want) {
+		t.Errorf("OptionsFromEnv returned %+v, %v, want %+v", opts, err, want)
+	}
+
+	for _, v := range []string{"acme", "acme=-1", "{acme}=1"} {
+		t.Setenv(EnvConnectionLimits, v)
+		if _, err := OptionsFromEnv(); err == nil {
+			t.Errorf("OptionsFromEnv accepted %s %q", EnvConnectionLimits, v)
+		}
+	}
+}
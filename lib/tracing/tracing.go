@@ -0,0 +1,149 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package tracing configures OpenTelemetry tracing for the AWS Lambda handlers. The tracer provider is registered
+// globally once per execution context by Setup, and the spans of each invocation are flushed by Flush before the
+// execution context is frozen.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// EnvExporter is the environment variable selecting the span exporter. The OTLP exporter is further configured with
+// the standard OTEL_EXPORTER_OTLP_* environment variables.
+const EnvExporter = "OTEL_TRACES_EXPORTER"
+
+// Exporters supported by Setup.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// instrumentation is the name of the tracer used by the application.
+const instrumentation = "com.aws-samples/apigateway.websockets.golang"
+
+// Setup registers the global tracer provider and propagator. The exporter is selected by OTEL_TRACES_EXPORTER and
+// defaults to none, in which case spans are created and propagated but not exported. The returned function shuts down
+// the tracer provider.
+func Setup(ctx context.Context, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	name := os.Getenv(EnvExporter)
+	if name == "" {
+		name = ExporterNone
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("unsupported %s %q", EnvExporter, name)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s span exporter: %w", name, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", service)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Flush exports all spans which have ended. AWS Lambda freezes the execution context once the handler returns, so the
+// handlers flush the spans of each invocation before returning.
+func Flush(ctx context.Context) error {
+	if p, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		return p.ForceFlush(ctx)
+	}
+
+	return nil
+}
+
+// Tracer returns the application's tracer from the global tracer provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// End records the error, if any, on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// Inject returns the trace context of the provided context as a map, suitable for embedding in a message envelope.
+// An empty map is returned as nil.
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+
+	return carrier
+}
+
+// Extract returns the span context held by the provided map, as created by Inject. The returned span context is
+// invalid if the map holds no trace context.
+func Extract(carrier map[string]string) trace.SpanContext {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(carrier))
+	return trace.SpanContextFromContext(ctx)
+}
+
+// RequestAttributes returns the span attributes describing the WebSocket request.
+func RequestAttributes(req *events.APIGatewayWebsocketProxyRequest) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("websocket.connection_id", req.RequestContext.ConnectionID),
+		attribute.String("websocket.route_key", req.RequestContext.RouteKey),
+		attribute.String("websocket.stage", req.RequestContext.Stage),
+		attribute.String("aws.apigateway.request_id", req.RequestContext.RequestID),
+	}
+}
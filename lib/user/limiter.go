@@ -0,0 +1,185 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package user tracks the connections of each authenticated user and limits their number. The user is identified by a
+// claim of the context returned by the API's Lambda authorizer, and the connections of each user are tracked in a
+// sorted set of the user's tenant, scored by the time they were established.
+package user
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"github.com/aws/aws-lambda-go/events"
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// MaxIDLength is the maximum length of a user ID.
+const MaxIDLength = 256
+
+// connectionLifetime is the maximum duration of an Amazon API Gateway WebSocket connection. Older entries are no longer
+// counted towards the limit, as their connection is closed even if the disconnect route was not invoked for it.
+const connectionLifetime = 2 * time.Hour
+
+// ErrInvalidID is returned when the user ID is too long or holds braces, which would break the hash tag of its key.
+var ErrInvalidID = errors.New("invalid user id")
+
+// connectScript adds the connection to the connections of the user after dropping the entries older than the maximum
+// connection lifetime. When the user reached the limit, the script either rejects the connection or removes the
+// oldest connections to make room for it. The script replies with "1" followed by the IDs of the removed connections
+// if the connection was added, and with "0" otherwise.
+var connectScript = radix.NewEvalScript(1, `
+local now = tonumber(ARGV[2])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - tonumber(ARGV[3]))
+local limit = tonumber(ARGV[4])
+local evicted = {}
+if limit > 0 then
+	local count = redis.call("ZCARD", KEYS[1])
+	if count >= limit then
+		if ARGV[5] ~= "evict" then
+			return {"0"}
+		end
+		evicted = redis.call("ZRANGE", KEYS[1], 0, count - limit)
+		redis.call("ZREM", KEYS[1], unpack(evicted))
+	end
+end
+redis.call("ZADD", KEYS[1], now, ARGV[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+table.insert(evicted, 1, "1")
+return evicted
+`)
+
+// Admission is the outcome of Limiter.Connect.
+type Admission struct {
+	// Accepted is false when the connection was rejected as the user reached the connection limit.
+	Accepted bool
+
+	// Evicted holds the IDs of the oldest connections of the user which were removed to make room for the new one.
+	// The caller is expected to close them.
+	Evicted []string
+}
+
+// Limiter tracks the connections of each user and limits their number.
+type Limiter struct {
+	redis redis.Client
+	opts  Options
+}
+
+// NewLimiter creates a new Limiter.
+func NewLimiter(client redis.Client, opts Options) *Limiter {
+	return &Limiter{redis: client, opts: opts}
+}
+
+// ID returns the user ID of the request from the context of the Lambda authorizer, if any.
+func (l *Limiter) ID(req *events.APIGatewayWebsocketProxyRequest) (string, bool) {
+	claims, ok := req.RequestContext.Authorizer.(map[string]interface{})
+	if !ok || l.opts.AuthorizerKey == "" {
+		return "", false
+	}
+
+	id, ok := claims[l.opts.AuthorizerKey].(string)
+	return id, ok && id != ""
+}
+
+// Connect records the connection of the connect request for its user, atomically enforcing the connection limit
+// according to the Policy. Connections without a user are always accepted. When there is no limit, the connections of
+// users whose ID can not be tracked are accepted as well. The user of the connection is recorded before the limit is
+// enforced, so that no connections are evicted for a connection which then fails to be recorded. A connection which is
+// rejected, or which fails to connect, must be removed with Disconnect.
+func (l *Limiter) Connect(ctx context.Context, ks redis.Keyspace,
+	req *events.APIGatewayWebsocketProxyRequest) (Admission, error) {
+	id, ok := l.ID(req)
+	if !ok {
+		return Admission{Accepted: true}, nil
+	}
+
+	if err := validate(id); err != nil {
+		if l.opts.MaxConnections == 0 {
+			return Admission{Accepted: true}, nil
+		}
+
+		return Admission{}, err
+	}
+
+	// Record the user of the connection, so that it can be removed from the connections of the user when it is closed
+	// outside of its own requests, for example by the server. The connection and the connections of the user are held
+	// in different hash slots, so they can not be updated by the same script.
+	lifetime := strconv.FormatInt(connectionLifetime.Milliseconds(), 10)
+	key := ks.ConnectionKey(req.RequestContext.ConnectionID, "user")
+	if err := redis.Do(ctx, l.redis, "SET", radix.Cmd(nil, "SET", key, id, "PX", lifetime)); err != nil {
+		return Admission{}, err
+	}
+
+	var reply []string
+	now := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	err := redis.Do(ctx, l.redis, "EVALSHA", connectScript.Cmd(&reply, ks.Key("user:"+id, "connections"),
+		req.RequestContext.ConnectionID, now, lifetime, strconv.Itoa(l.opts.MaxConnections), string(l.opts.Policy)))
+	if err != nil || len(reply) == 0 || reply[0] != "1" {
+		return Admission{}, err
+	}
+
+	return Admission{Accepted: true, Evicted: reply[1:]}, nil
+}
+
+// Disconnect removes the connection from the connections of its user, as recorded by Connect.
+func (l *Limiter) Disconnect(ctx context.Context, ks redis.Keyspace, connectionID string) error {
+	var id string
+	stored := radix.MaybeNil{Rcv: &id}
+	key := ks.ConnectionKey(connectionID, "user")
+	if err := redis.Do(ctx, l.redis, "GET", radix.Cmd(&stored, "GET", key)); err != nil || stored.Nil {
+		return err
+	}
+
+	err := redis.Do(ctx, l.redis, "ZREM", radix.Cmd(nil, "ZREM", ks.Key("user:"+id, "connections"), connectionID))
+	if err != nil {
+		return err
+	}
+
+	return redis.Do(ctx, l.redis, "DEL", radix.Cmd(nil, "DEL", key))
+}
+
+// Connections returns the IDs of the connections of the user, as recorded by Connect, omitting the entries older than
+// the maximum connection lifetime. Unknown users have no connections.
+func (l *Limiter) Connections(ctx context.Context, ks redis.Keyspace, id string) ([]string, error) {
+	if validate(id) != nil {
+		return nil, nil
+	}
+
+	var connections []string
+	min := time.Now().Add(-connectionLifetime).UnixNano() / int64(time.Millisecond)
+	err := redis.DoRead(ctx, l.redis, "ZRANGEBYSCORE", radix.Cmd(&connections, "ZRANGEBYSCORE",
+		ks.Key("user:"+id, "connections"), strconv.FormatInt(min, 10), "+inf"))
+	return connections, err
+}
+
+// validate verifies that the user ID can be used in the hash tag of a key.
+func validate(id string) error {
+	if len(id) > MaxIDLength || strings.ContainsAny(id, "{}") {
+		return ErrInvalidID
+	}
+
+	return nil
+}
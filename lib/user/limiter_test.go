@@ -0,0 +1,232 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package user
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis/redistest"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+var ks = redis.Tenant("acme")
+
+// newLimiter returns a Limiter backed by an in-memory client which runs the connect script.
+func newLimiter(opts Options) (*Limiter, *redistest.Store) {
+	client := redistest.NewStore()
+	client.Eval = func(_ string, keys, args []string) interface{} {
+		now, _ := strconv.ParseFloat(args[1], 64)
+		lifetime, _ := strconv.ParseFloat(args[2], 64)
+		limit, _ := strconv.Atoi(args[3])
+		connections := client.ZSets[keys[0]]
+		if connections == nil {
+			connections = make(map[string]float64)
+			client.ZSets[keys[0]] = connections
+		}
+
+		for id, score := range connections {
+			if score <= now-lifetime {
+				delete(connections, id)
+			}
+		}
+
+		evicted := []string{}
+		if limit > 0 && len(connections) >= limit {
+			if args[4] != "evict" {
+				return []string{"0"}
+			}
+
+			evicted = client.SortedSet(keys[0])[:len(connections)-limit+1]
+			for _, id := range evicted {
+				delete(connections, id)
+			}
+		}
+
+		connections[args[0]] = now
+		return append([]string{"1"}, evicted...)
+	}
+
+	return NewLimiter(client, opts), client
+}
+
+// request returns a connect request of the connection for the provided user.
+func request(connectionID, id string) *events.APIGatewayWebsocketProxyRequest {
+	req := &events.APIGatewayWebsocketProxyRequest{}
+	req.RequestContext.ConnectionID = connectionID
+	if id != "" {
+		req.RequestContext.Authorizer = map[string]interface{}{"principalId": id}
+	}
+
+	return req
+}
+
+func TestID(t *testing.T) {
+	l := NewLimiter(nil, DefaultOptions())
+	if id, ok := l.ID(request("conn1", "alice")); id != "alice" || !ok {
+		t.Errorf("ID returned %q, %v, want %q", id, ok, "alice")
+	}
+
+	if id, ok := l.ID(request("conn1", "")); ok {
+		t.Errorf("ID of a request without authorizer context returned %q", id)
+	}
+
+	l = NewLimiter(nil, Options{})
+	if id, ok := l.ID(request("conn1", "alice")); ok {
+		t.Errorf("ID without an authorizer key returned %q", id)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		want    []Admission
+		tracked []string
+	}{
+		{"reject", PolicyReject,
+			[]Admission{{Accepted: true, Evicted: []string{}}, {Accepted: true, Evicted: []string{}}, {}},
+			[]string{"conn0", "conn1"}},
+		{"evict", PolicyEvict,
+			[]Admission{{Accepted: true, Evicted: []string{}}, {Accepted: true, Evicted: []string{}},
+				{Accepted: true, Evicted: []string{"conn0"}}},
+			[]string{"conn1", "conn2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, client := newLimiter(Options{AuthorizerKey: "principalId", MaxConnections: 2, Policy: tt.policy})
+			ctx := context.Background()
+			for i, want := range tt.want {
+				id := "conn" + strconv.Itoa(i)
+				got, err := l.Connect(ctx, ks, request(id, "alice"))
+				if err != nil || !reflect.DeepEqual(got, want) {
+					t.Fatalf("Connect of %s returned %+v, %v, want %+v", id, got, err, want)
+				}
+
+				// The user is recorded even for rejected connections, which are then removed with Disconnect.
+				if user := client.Strings[ks.ConnectionKey(id, "user")]; user != "alice" {
+					t.Errorf("user of %s recorded as %q, want %q", id, user, "alice")
+				}
+
+				// Keep the scores of the connections apart, so that the oldest one is evicted.
+				connections := client.ZSets[ks.Key("user:alice", "connections")]
+				if _, ok := connections[id]; ok {
+					connections[id] = float64(time.Now().Add(time.Duration(i-len(tt.want)) * time.Second).UnixMilli())
+				}
+			}
+
+			got, err := l.Connections(ctx, ks, "alice")
+			if err != nil || !reflect.DeepEqual(got, tt.tracked) {
+				t.Errorf("Connections returned %v, %v, want %v", got, err, tt.tracked)
+			}
+		})
+	}
+}
+
+func TestConnectExpired(t *testing.T) {
+	l, client := newLimiter(Options{AuthorizerKey: "principalId", MaxConnections: 1, Policy: PolicyReject})
+	key := ks.Key("user:alice", "connections")
+	client.ZSets[key] = map[string]float64{"stale": float64(time.Now().Add(-3 * time.Hour).UnixMilli())}
+	if got, err := l.Connect(context.Background(), ks, request("conn1", "alice")); err != nil || !got.Accepted {
+		t.Errorf("Connect returned %+v, %v, want the connection to replace the expired one", got, err)
+	}
+
+	if _, ok := client.ZSets[key]["stale"]; ok {
+		t.Error("expired connection is still tracked")
+	}
+}
+
+func TestConnectWithoutUser(t *testing.T) {
+	l, client := newLimiter(Options{AuthorizerKey: "principalId", MaxConnections: 1})
+	ctx := context.Background()
+	if got, err := l.Connect(ctx, ks, request("conn1", "")); err != nil || !got.Accepted {
+		t.Errorf("Connect without a user returned %+v, %v, want it accepted", got, err)
+	}
+
+	invalid := strings.Repeat("a", MaxIDLength+1)
+	if _, err := l.Connect(ctx, ks, request("conn1", invalid)); err != ErrInvalidID {
+		t.Errorf("Connect of an invalid user returned %v, want %v", err, ErrInvalidID)
+	}
+
+	l = NewLimiter(client, DefaultOptions())
+	if got, err := l.Connect(ctx, ks, request("conn1", "{alice}")); err != nil || !got.Accepted {
+		t.Errorf("Connect of an invalid user without limit returned %+v, %v, want it accepted", got, err)
+	}
+
+	if len(client.Strings) != 0 || len(client.ZSets) != 0 {
+		t.Errorf("Connect recorded untracked connections: %v, %v", client.Strings, client.ZSets)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	l, client := newLimiter(Options{AuthorizerKey: "principalId", MaxConnections: 2})
+	ctx := context.Background()
+	for _, id := range []string{"conn1", "conn2"} {
+		if _, err := l.Connect(ctx, ks, request(id, "alice")); err != nil {
+			t.Fatalf("Connect returned error: %v", err)
+		}
+	}
+
+	for _, id := range []string{"conn1", "unknown"} {
+		if err := l.Disconnect(ctx, ks, id); err != nil {
+			t.Fatalf("Disconnect of %s returned error: %v", id, err)
+		}
+	}
+
+	if got, err := l.Connections(ctx, ks, "alice"); err != nil || !reflect.DeepEqual(got, []string{"conn2"}) {
+		t.Errorf("Connections returned %v, %v, want [conn2]", got, err)
+	}
+
+	if _, ok := client.Strings[ks.ConnectionKey("conn1", "user")]; ok {
+		t.Error("user of the disconnected connection is still recorded")
+	}
+
+	if got, err := l.Connections(ctx, ks, "{alice}"); got != nil || err != nil {
+		t.Errorf("Connections of an invalid user returned %v, %v", got, err)
+	}
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Setenv(EnvMaxConnections, "3")
+	t.Setenv(EnvLimitPolicy, "evict")
+	opts, err := OptionsFromEnv()
+	want := Options{AuthorizerKey: "principalId", MaxConnections: 3, Policy: PolicyEvict}
+	if err != nil || opts != want {
+		t.Errorf("OptionsFromEnv returned %+v, %v, want %+v", opts, err, want)
+	}
+
+	for k, v := range map[string]string{EnvMaxConnections: "-1", EnvLimitPolicy: "drop"} {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv(k, v)
+			if _, err := OptionsFromEnv(); err == nil {
+				t.Errorf("OptionsFromEnv accepted %s %q", k, v)
+			}
+		})
+	}
+}
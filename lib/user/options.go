@@ -0,0 +1,96 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package user
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Environment variables read by OptionsFromEnv.
+const (
+	EnvAuthorizerKey  = "USER_AUTHORIZER_KEY"
+	EnvMaxConnections = "USER_MAX_CONNECTIONS"
+	EnvLimitPolicy    = "USER_LIMIT_POLICY"
+)
+
+// Policy decides what happens to a new connection of a user who reached the connection limit.
+type Policy string
+
+const (
+	// PolicyReject rejects the new connection.
+	PolicyReject Policy = "reject"
+
+	// PolicyEvict accepts the new connection and closes the oldest connections of the user.
+	PolicyEvict Policy = "evict"
+)
+
+// Options configures the Limiter.
+type Options struct {
+	// AuthorizerKey is the key of the user ID in the context returned by the Lambda authorizer of the API. Connections
+	// without a user ID are not limited.
+	AuthorizerKey string
+
+	// MaxConnections is the maximum number of connections of each user. Zero means no limit.
+	MaxConnections int
+
+	// Policy decides what happens to connections beyond MaxConnections.
+	Policy Policy
+}
+
+// DefaultOptions returns the Options used when no environment variables are set.
+func DefaultOptions() Options {
+	return Options{
+		AuthorizerKey: "principalId",
+		Policy:        PolicyReject,
+	}
+}
+
+// OptionsFromEnv returns DefaultOptions overridden by any of the USER_* environment variables which are set.
+func OptionsFromEnv() (Options, error) {
+	opts := DefaultOptions()
+	if v, ok := os.LookupEnv(EnvAuthorizerKey); ok {
+		opts.AuthorizerKey = v
+	}
+
+	if v := os.Getenv(EnvMaxConnections); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("invalid %s %q: must be a non-negative integer", EnvMaxConnections, v)
+		}
+
+		opts.MaxConnections = n
+	}
+
+	if v := os.Getenv(EnvLimitPolicy); v != "" {
+		switch p := Policy(v); p {
+		case PolicyReject, PolicyEvict:
+			opts.Policy = p
+		default:
+			return opts, fmt.Errorf("invalid %s %q: must be %s or %s", EnvLimitPolicy, v, PolicyReject, PolicyEvict)
+		}
+	}
+
+	return opts, nil
+}
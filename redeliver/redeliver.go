@@ -0,0 +1,103 @@
+// MIT No Attribution
+
+// Copyright 2020 Amazon.com, Inc. or its affiliates.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"os"
+
+	"com.aws-samples/apigateway.websockets.golang/lib/ack"
+	"com.aws-samples/apigateway.websockets.golang/lib/apigw"
+	"com.aws-samples/apigateway.websockets.golang/lib/handler/redeliver"
+	"com.aws-samples/apigateway.websockets.golang/lib/logger"
+	"com.aws-samples/apigateway.websockets.golang/lib/metrics"
+	"com.aws-samples/apigateway.websockets.golang/lib/redis"
+	"com.aws-samples/apigateway.websockets.golang/lib/session"
+	"com.aws-samples/apigateway.websockets.golang/lib/tenant"
+	"com.aws-samples/apigateway.websockets.golang/lib/tracing"
+	"com.aws-samples/apigateway.websockets.golang/lib/user"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"go.uber.org/zap"
+)
+
+// Environment variables holding the endpoint of the application's API, which can not be derived from a scheduled
+// invocation.
+const (
+	EnvDomain = "WEBSOCKET_DOMAIN"
+	EnvStage  = "WEBSOCKET_STAGE"
+)
+
+// main creates the handler's dependencies once per AWS Lambda execution context and starts the handler. Creating the
+// dependencies outside of the handler allows them to be reused across subsequent invocations.
+func main() {
+	cfg, err := apigw.LoadConfig()
+	if err != nil {
+		logger.Instance.Panic("unable to load SDK config", zap.Error(err))
+	}
+
+	domain, stage := os.Getenv(EnvDomain), os.Getenv(EnvStage)
+	if domain == "" || stage == "" {
+		logger.Instance.Panic("websocket endpoint not configured",
+			zap.String("domain", domain),
+			zap.String("stage", stage),
+		)
+	}
+
+	if _, err := tracing.Setup(context.Background(), "redeliver"); err != nil {
+		logger.Instance.Panic("unable to configure tracing", zap.Error(err))
+	}
+
+	ackOptions, err := ack.OptionsFromEnv()
+	if err != nil {
+		logger.Instance.Panic("unable to read ack configuration", zap.Error(err))
+	}
+
+	tenantOptions, err := tenant.OptionsFromEnv()
+	if err != nil {
+		logger.Instance.Panic("unable to read tenant configuration", zap.Error(err))
+	}
+
+	userOptions, err := user.OptionsFromEnv()
+	if err != nil {
+		logger.Instance.Panic("unable to read user configuration", zap.Error(err))
+	}
+
+	opts, err := redis.OptionsFromEnv()
+	if err != nil {
+		logger.Instance.Panic("unable to read redis configuration", zap.Error(err))
+	}
+
+	client, err := redis.NewClient(opts)
+	if err != nil {
+		logger.Instance.Panic("unable to create redis client", zap.Error(err))
+	}
+
+	closer := session.NewCloser(client, tenant.NewResolver(client, tenantOptions), user.NewLimiter(client, userOptions))
+	lambda.Start(redeliver.NewHandler(redeliver.Dependencies{
+		Acks:          ack.NewStore(client, ackOptions),
+		Closer:        closer,
+		ManagementAPI: apigw.NewAPIGatewayManagementClient(&cfg, domain, stage),
+		Metrics:       metrics.NewEmitter(metrics.NamespaceFromEnv(), metrics.NewWriterSink(os.Stdout)),
+	}).Handle)
+}